@@ -6,23 +6,293 @@
 package cjsexports
 
 import (
+	"fmt"
+	"math"
+	"path"
 	"regexp"
+	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf16"
 
 	"github.com/aperturerobotics/esbuild/internal/ast"
+	"github.com/aperturerobotics/esbuild/internal/config"
 	"github.com/aperturerobotics/esbuild/internal/helpers"
 	"github.com/aperturerobotics/esbuild/internal/js_ast"
 	"github.com/aperturerobotics/esbuild/internal/js_parser"
 	"github.com/aperturerobotics/esbuild/internal/logger"
+	"github.com/aperturerobotics/esbuild/internal/resolver"
 )
 
 // Result contains the detected CJS exports from a module.
 type Result struct {
 	// Exports are the named export identifiers found.
 	Exports []string
-	// Reexports are module paths being re-exported via require().
+	// Reexports are module paths whose exports are all re-exported via
+	// require(). Each path is listed once. Selectively forwarded names like
+	// exports.foo = require("./a").foo are reported in Exports instead, even
+	// when the same path is also re-exported as a whole.
 	Reexports []string
+	// ExportCount and ReexportCount are the lengths of Exports and Reexports.
+	// They are set even when Options.CountOnly leaves those slices nil.
+	ExportCount   int
+	ReexportCount int
+	// HasDefault reports whether importing the module's default export yields
+	// a meaningful value, either because a "default" export was found or
+	// because module.exports itself serves as the default.
+	HasDefault bool
+	// DefaultName is the original name of the function, class, or variable
+	// assigned to module.exports or exports.default, e.g. "App" for
+	// module.exports = App. It is empty for anonymous functions and object
+	// literals.
+	DefaultName string
+	// HasUnknownExports reports that module.exports was assigned a value whose
+	// names couldn't be enumerated, such as the result of
+	// Object.entries(x).reduce(...). The module may export more than Exports
+	// and Reexports list.
+	HasUnknownExports bool
+	// Requires are all module paths passed to require() that were recognized
+	// during analysis, whether or not they are re-exported. Only populated
+	// when Options.CollectRequires is set.
+	Requires []string
+	// ResolvedRequires are the paths passed to require.resolve(), which look
+	// up a module without loading it. They are never reexports. Only
+	// populated when Options.CollectRequires is set.
+	ResolvedRequires []string
+	// ReexportKinds maps each reexport path to its kind: ReexportRelative,
+	// ReexportBuiltin, or ReexportPackage. Only populated when
+	// Options.ClassifyReexports is set.
+	ReexportKinds map[string]string
+	// ExportSources maps export names to the module they are forwarded from,
+	// e.g. exports.api = require("./impl").api. Only populated when
+	// Options.TrackExportSources is set.
+	ExportSources map[string]ExportSource
+	// ReexportedNames maps each resolved reexport path to the names it
+	// contributes to Exports. Names defined locally or by an earlier path in
+	// sorted order are omitted. Only populated when Options.ResolveReexport
+	// is set.
+	ReexportedNames map[string][]string
+
+	// AST is the parsed syntax tree. Only populated when Options.RetainAST is set.
+	AST *js_ast.AST
+	// ModuleRef and ExportsRef are the symbols the parser assigned to the CJS
+	// `module` and `exports` bindings. They index into AST.Symbols and are only
+	// valid alongside the retained AST.
+	ModuleRef  ast.Ref
+	ExportsRef ast.Ref
+
+	// ConditionalExports and ConditionalReexports list the entries of Exports
+	// and Reexports that were only found inside a try or catch block, which
+	// may not run to completion, or in one branch of a module.exports ternary
+	// whose test couldn't be evaluated. Only populated when
+	// Options.MarkConditional is set.
+	ConditionalExports   []string
+	ConditionalReexports []string
+
+	// Warnings describe constructs the analyzer could not extract exports
+	// from, in source order. Only populated when Options.ReportUnknownPatterns
+	// is set.
+	Warnings []Warning
+
+	// Stats counts how often each export pattern was matched, keyed by pattern
+	// category such as "defineProperty", "objectAssign", "exportStar", or
+	// "moduleExportsObject". Only populated when Options.CollectStats is set.
+	Stats map[string]int
+
+	// LineCount and ByteCount measure the decoded UTF-8 source. A final line
+	// without a trailing newline is counted. Only populated when
+	// Options.CollectSourceMetrics is set.
+	LineCount int
+	ByteCount int
+}
+
+// Empty reports whether the module exposes nothing: no named exports, no
+// reexports, no default, and no unenumerable module.exports value. It checks
+// the signal fields Exports, Reexports, ExportCount, ReexportCount,
+// HasDefault, and HasUnknownExports. Requires and other metadata are not
+// considered. A nil Result is empty.
+func (r *Result) Empty() bool {
+	if r == nil {
+		return true
+	}
+	return len(r.Exports) == 0 && len(r.Reexports) == 0 &&
+		r.ExportCount == 0 && r.ReexportCount == 0 && !r.HasDefault &&
+		!r.HasUnknownExports
+}
+
+// PureReexport reports whether the module only forwards another module, as
+// in module.exports = require("./impl"), and returns that module's path. It
+// returns false when there are own exports or more than one reexport.
+func (r *Result) PureReexport() (string, bool) {
+	if r == nil || len(r.Exports) != 0 || len(r.Reexports) != 1 {
+		return "", false
+	}
+	return r.Reexports[0], true
+}
+
+// Diff compares r against prev, an earlier result for the same module, and
+// returns the export names and reexport paths that were added or removed.
+// Each returned slice is sorted. Either result may be nil.
+func (r *Result) Diff(prev *Result) (addedExports, removedExports, addedReexports, removedReexports []string) {
+	var exports, reexports, prevExports, prevReexports []string
+	if r != nil {
+		exports, reexports = r.Exports, r.Reexports
+	}
+	if prev != nil {
+		prevExports, prevReexports = prev.Exports, prev.Reexports
+	}
+	addedExports, removedExports = diffNames(exports, prevExports)
+	addedReexports, removedReexports = diffNames(reexports, prevReexports)
+	return
+}
+
+// ToExportMap returns each export name mapped to the path of the module it is
+// forwarded from, or "" for exports defined locally or whose origin is
+// unknown. Origins are only known when Options.TrackExportSources was set.
+func (r *Result) ToExportMap() map[string]string {
+	if r == nil {
+		return nil
+	}
+	m := make(map[string]string, len(r.Exports))
+	for _, name := range r.Exports {
+		m[name] = r.ExportSources[name].Path
+	}
+	return m
+}
+
+// diffNames returns the sorted names only in cur and only in prev.
+func diffNames(cur, prev []string) (added, removed []string) {
+	curSet := make(map[string]struct{}, len(cur))
+	for _, name := range cur {
+		curSet[name] = struct{}{}
+	}
+	prevSet := make(map[string]struct{}, len(prev))
+	for _, name := range prev {
+		prevSet[name] = struct{}{}
+	}
+	for name := range curSet {
+		if _, ok := prevSet[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for name := range prevSet {
+		if _, ok := curSet[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Encoding is the character encoding of a source passed to Parse.
+type Encoding uint8
+
+const (
+	// EncodingUTF8 is the default. Sources that start with a UTF-16 byte
+	// order mark are still detected and decoded as UTF-16.
+	EncodingUTF8 Encoding = iota
+	// EncodingUTF16LE is little-endian UTF-16, as written by many Windows tools.
+	EncodingUTF16LE
+	// EncodingUTF16BE is big-endian UTF-16.
+	EncodingUTF16BE
+)
+
+// decodeSource converts source to UTF-8 and removes any byte order mark, so
+// that text scans and reported offsets see the same code as the parser.
+func decodeSource(source string, encoding Encoding) string {
+	if encoding == EncodingUTF8 {
+		switch {
+		case strings.HasPrefix(source, "\xef\xbb\xbf"):
+			return source[3:]
+		case strings.HasPrefix(source, "\xff\xfe"):
+			encoding = EncodingUTF16LE
+		case strings.HasPrefix(source, "\xfe\xff"):
+			encoding = EncodingUTF16BE
+		default:
+			return source
+		}
+	}
+
+	units := make([]uint16, 0, len(source)/2)
+	for i := 0; i+1 < len(source); i += 2 {
+		if encoding == EncodingUTF16LE {
+			units = append(units, uint16(source[i])|uint16(source[i+1])<<8)
+		} else {
+			units = append(units, uint16(source[i])<<8|uint16(source[i+1]))
+		}
+	}
+	if len(units) > 0 && units[0] == 0xfeff {
+		units = units[1:]
+	}
+	return string(utf16.Decode(units))
+}
+
+// Kinds of reexport specifiers reported in Result.ReexportKinds.
+const (
+	// ReexportRelative is a relative or absolute file path like "./impl".
+	ReexportRelative = "relative"
+	// ReexportBuiltin is a Node.js builtin module like "fs" or "node:path".
+	ReexportBuiltin = "builtin"
+	// ReexportPackage is any other bare specifier like "lodash/fp".
+	ReexportPackage = "package"
+)
+
+// classifySpecifier returns the kind of a require() specifier based on its
+// shape alone, without resolving it.
+func classifySpecifier(spec string) string {
+	switch {
+	case spec == "." || spec == ".." || strings.HasPrefix(spec, "./") ||
+		strings.HasPrefix(spec, "../") || strings.HasPrefix(spec, "/"):
+		return ReexportRelative
+	case strings.HasPrefix(spec, "node:") || resolver.BuiltInNodeModules[spec]:
+		return ReexportBuiltin
+	}
+	return ReexportPackage
+}
+
+// Warning describes a construct that the analyzer did not recognize.
+type Warning struct {
+	// Text describes the construct, including its syntax node kind.
+	Text string
+	// Loc is the byte offset of the construct in the source.
+	Loc logger.Loc
+}
+
+// ExportSource describes where a named export is forwarded from.
+type ExportSource struct {
+	// Path is the require() path the export is read from.
+	Path string
+	// Member is the property read off the required module, or empty if the
+	// whole module is exported under this name.
+	Member string
+}
+
+// Loader selects the syntax a source is parsed with.
+type Loader uint8
+
+const (
+	// LoaderDefault infers the loader from the filename extension, falling
+	// back to LoaderJS.
+	LoaderDefault Loader = iota
+	LoaderJS
+	LoaderJSX
+	LoaderTS
+	LoaderTSX
+)
+
+// defaultExtensionLoaders maps filename extensions to loaders when the
+// extension isn't listed in Options.ExtensionLoaders.
+var defaultExtensionLoaders = map[string]Loader{
+	".js":  LoaderJS,
+	".cjs": LoaderJS,
+	".mjs": LoaderJS,
+	".jsx": LoaderJSX,
+	".ts":  LoaderTS,
+	".mts": LoaderTS,
+	".cts": LoaderTS,
+	".tsx": LoaderTSX,
 }
 
 // Options configures CJS export detection.
@@ -32,22 +302,316 @@ type Options struct {
 	NodeEnv string
 	// CallMode analyzes function return exports (for module.exports = function(){...}).
 	CallMode bool
+	// CollectRequires records every recognized require() path in Result.Requires.
+	CollectRequires bool
+	// TrackExportSources records which named exports are forwarded from a
+	// required module in Result.ExportSources.
+	TrackExportSources bool
+	// RequireMainIsModule evaluates `require.main === module` guards as true
+	// and `module.parent` as unset, as when the module is run as the program
+	// entry point. By default the library branch is analyzed instead.
+	RequireMainIsModule bool
+	// HonorExportDirectives adds names listed in a leading /** @exports foo, bar */
+	// comment to the exports. Intended for generated files that declare their
+	// exports up front.
+	HonorExportDirectives bool
+	// SynthesizeDefault sets Result.HasDefault when module.exports is replaced
+	// with an object literal and the module is not marked __esModule, matching
+	// Node's ESM interop where the whole object is the default import.
+	SynthesizeDefault bool
+	// RetainAST keeps the parsed AST and the module/exports symbol refs in the
+	// Result for callers that want to run their own analysis afterwards.
+	RetainAST bool
+	// ResolveReexport, if set, is called with each reexport path as written
+	// and the module reexporting it, and returns a name identifying the
+	// reexported module, such as its resolved file path, along with its
+	// analyzed Result, or false if it can't be resolved. The importer of the
+	// module's own reexports is the filename passed to Parse, and the
+	// reexports of a resolved Result are resolved in turn with the returned
+	// name as importer. Resolved names are merged into Result.Exports. When a
+	// name is defined both locally and by a reexported module, it is reported
+	// once and the local definition takes precedence.
+	ResolveReexport func(importer, path string) (resolved string, result *Result, ok bool)
+	// CollectStats counts how often each export pattern was matched in
+	// Result.Stats.
+	CollectStats bool
+	// InstanceExports lists the instance properties of a locally defined class
+	// for module.exports = new SomeClass(). Only class fields and this.x
+	// assignments in the constructor are considered.
+	InstanceExports bool
+	// Loader selects the syntax the source is parsed with. When unset, it is
+	// inferred from the filename extension.
+	Loader Loader
+	// ExtensionLoaders overrides the loader inferred for a filename extension
+	// (including the leading dot, e.g. ".cjs") when Loader is unset. Extensions
+	// not listed fall back to the defaults for .js, .jsx, .ts, .tsx, .mts, and .cts.
+	ExtensionLoaders map[string]Loader
+	// DisablePanicRecover lets panics during analysis propagate. By default
+	// they are recovered and returned as a *PanicError so that one bad file
+	// doesn't crash a long-running scanner.
+	DisablePanicRecover bool
+	// NormalizeReexportPaths cleans relative reexport specifiers, so that
+	// "./lib/../util/" is reported as "./util". Package specifiers, including
+	// scoped packages and subpaths like "@scope/pkg/sub", are left untouched.
+	NormalizeReexportPaths bool
+	// MarkConditional reports exports and reexports that are only found inside
+	// a try or catch block, or in a branch of an unresolved module.exports
+	// ternary, in Result.ConditionalExports and Result.ConditionalReexports.
+	// Exports from a finally block always run and are not marked.
+	MarkConditional bool
+	// OnExport and OnReexport are called once for each export name and
+	// reexport path when analysis of a module finishes. They are not called
+	// while walking because a later module.exports assignment can discard
+	// what was found so far. When either is set, Result.Exports and
+	// Result.Reexports are left nil unless CollectResult is also set, and the
+	// names are neither collected into a slice nor sorted. The calls are then
+	// made in an unspecified order, or in sorted order with CollectResult.
+	OnExport   func(name string)
+	OnReexport func(path string)
+	// CollectResult keeps Result.Exports and Result.Reexports populated when
+	// OnExport or OnReexport is set.
+	CollectResult bool
+	// ReportUnknownPatterns adds a Result.Warnings entry whenever
+	// module.exports is assigned a value that no names could be extracted
+	// from, such as the result of an unknown function call, and for
+	// exports.name assignments made dead by replacing module.exports.
+	ReportUnknownPatterns bool
+	// CaseInsensitiveReexports merges reexport paths that differ only in
+	// case, such as "./Foo" and "./foo", keeping the casing seen first. This
+	// matches case-insensitive filesystems like the Windows and macOS
+	// defaults, but on case-sensitive systems those paths may name different
+	// files, so only enable it when analyzing sources from such a platform.
+	// Export names are always case-sensitive.
+	CaseInsensitiveReexports bool
+	// DefaultExportName, if set, is added to the exports when module.exports
+	// is replaced by a function, class, or primitive, giving that value a
+	// named handle such as "default". Result.DefaultName still reports the
+	// value's own name.
+	DefaultExportName string
+	// CountOnly leaves Result.Exports and Result.Reexports nil, saving their
+	// allocation and sort when only Result.ExportCount and
+	// Result.ReexportCount are needed. Result.ConditionalExports and
+	// Result.ConditionalReexports are left nil too, even with
+	// MarkConditional.
+	CountOnly bool
+	// Define maps member expressions such as "process.platform" or
+	// "process.arch" to string values. They are substituted when folding a
+	// concatenated require() path, as in require("./impl-" + process.platform).
+	// Paths with parts that aren't defined stay unresolved.
+	Define map[string]string
+	// TSDeclare reads the exports of a TypeScript declaration file, such as
+	// "export declare const x", "export function f(): void;",
+	// "export default class Foo {}" and "export { A, B }", into
+	// Result.Exports. Comments and strings are skipped.
+	// Use it with LoaderTS, which .d.ts files get by default.
+	TSDeclare bool
+	// ExportsIdentifier and ModuleIdentifier replace the names "exports" and
+	// "module" for module systems that call these objects something else,
+	// such as __m.exports. The "exports" property name of the module object
+	// is unchanged.
+	ExportsIdentifier string
+	ModuleIdentifier  string
+	// RequireLikeFunctions lists function names that load a module like
+	// require does, such as loadModule or __importStar. A call to one is
+	// treated as require() of its first argument, which may itself be a
+	// require() call.
+	RequireLikeFunctions []string
+	// ClassifyReexports reports whether each reexport targets a relative
+	// file, a Node.js builtin, or a package in Result.ReexportKinds.
+	ClassifyReexports bool
+	// CopyPropsFunctions lists helper functions that copy the properties of
+	// their second argument onto their first, like copyProps(exports,
+	// require("./util")). When the first argument is exports or
+	// module.exports, required modules are recorded as reexports and object
+	// literal keys as exports, as for Object.assign.
+	CopyPropsFunctions []string
+	// Encoding is the character encoding of the source. A leading byte order
+	// mark is always removed, and offsets such as Warning.Loc refer to the
+	// decoded UTF-8 source.
+	Encoding Encoding
+	// ESModuleMarkerLast moves the __esModule marker to the end of
+	// Result.Exports so that the real exports are listed first.
+	ESModuleMarkerLast bool
+	// IncludeReexportFromExportStarObject also records a reexport for each
+	// property of an object passed to __exportStar whose value is read from
+	// a required module, e.g. "./a" for __exportStar({ a: require("./a").a },
+	// exports). The property names are always recorded as exports.
+	IncludeReexportFromExportStarObject bool
+	// IncludeESMExports also records the names exported by ES module export
+	// statements. export default sets Result.HasDefault and, for named
+	// functions, classes and identifiers, Result.DefaultName. export * from
+	// is recorded as a reexport.
+	IncludeESMExports bool
+	// ObjectSpreadFromFunctionResult analyzes the body of a local function
+	// whose result is spread into an exported object, like
+	// module.exports = { ...makeExports() }, to recover the returned keys.
+	// This is always done in CallMode.
+	ObjectSpreadFromFunctionResult bool
+	// OnlyReexports skips tracking named exports for callers that only need
+	// the reexport and require edges of a dependency graph. Exports is left
+	// nil, and the fields derived from it, such as ExportSources, HasDefault
+	// and DefaultName, are not reliable.
+	OnlyReexports bool
+	// MaxReexportDepth bounds how many levels of reexports are followed
+	// through Options.ResolveReexport, and defaults to 8. Reexport cycles
+	// are skipped. Both cases add a Result.Warnings entry when
+	// Options.ReportUnknownPatterns is set.
+	MaxReexportDepth int
+	// CollectSourceMetrics populates Result.LineCount and Result.ByteCount.
+	CollectSourceMetrics bool
 }
 
 // Parse analyzes JavaScript source code and returns detected CJS exports.
 func Parse(source string, filename string, opts Options) (*Result, error) {
-	log := logger.NewDeferLog(logger.DeferLogAll, logger.LevelSilent, nil)
+	return parse(source, filename, opts, newParseLog())
+}
+
+// ParseSafe is like Parse but never panics and never returns nil. If the
+// source fails to parse or analysis panics, it returns an empty Result whose
+// Warnings describe the failure. This suits fuzzers and callers handling
+// untrusted input that only care about whatever exports can be found.
+func ParseSafe(source string, filename string, opts Options) *Result {
+	opts.DisablePanicRecover = false
+	result, err := Parse(source, filename, opts)
+	if err != nil {
+		return &Result{Warnings: []Warning{{Text: err.Error()}}}
+	}
+	return result
+}
+
+// ParseMany analyzes each source in sources, keyed by filename, with the same
+// options. Files are parsed sequentially in filename order and share a single
+// message log that is reset between files, which avoids allocating a fresh
+// logger per file. Files that fail to parse are omitted from the result map
+// and have their error recorded in the returned error map instead.
+func ParseMany(sources map[string]string, opts Options) (map[string]*Result, map[string]error) {
+	filenames := make([]string, 0, len(sources))
+	for filename := range sources {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	results := make(map[string]*Result, len(sources))
+	errs := make(map[string]error)
+	log := newParseLog()
+	for _, filename := range filenames {
+		log.reset()
+		result, err := parse(sources[filename], filename, opts, log)
+		if err != nil {
+			errs[filename] = err
+			continue
+		}
+		results[filename] = result
+	}
+	return results, errs
+}
+
+// Module types returned by DetectModuleType.
+const (
+	ModuleTypeESM       = "module"
+	ModuleTypeCommonJS  = "commonjs"
+	ModuleTypeAmbiguous = "ambiguous"
+)
+
+// DetectModuleType infers whether source is an ES module or a CommonJS
+// module, approximating the syntax detection Node.js applies to .js files
+// without a package type. Top-level import/export statements and top-level
+// await mark the source as ESM, while detected exports or reexports and any
+// use of the free require, module or exports bindings mark it as CommonJS.
+// Sources showing both kinds of syntax, or neither, are ambiguous.
+func DetectModuleType(source string) (string, error) {
+	result, err := Parse(source, "", Options{RetainAST: true, CollectRequires: true})
+	if err != nil {
+		return "", err
+	}
+	tree := result.AST
+	esm := tree.ExportsKind == js_ast.ExportsESM
+	cjs := !result.Empty() || len(result.Requires) > 0
+	if !cjs {
+		for _, name := range []string{"require", "module", "exports"} {
+			if member, ok := tree.ModuleScope.Members[name]; ok &&
+				tree.Symbols[member.Ref.InnerIndex].Kind == ast.SymbolUnbound {
+				cjs = true
+				break
+			}
+		}
+	}
+	switch {
+	case esm && !cjs:
+		return ModuleTypeESM, nil
+	case cjs && !esm:
+		return ModuleTypeCommonJS, nil
+	}
+	return ModuleTypeAmbiguous, nil
+}
+
+// parseLog is a resettable message log for the parser. Unlike the loggers in
+// internal/logger it can be reused across files.
+type parseLog struct {
+	log       logger.Log
+	msgs      logger.SortableMsgs
+	hasErrors bool
+}
+
+func newParseLog() *parseLog {
+	l := &parseLog{}
+	l.log = logger.Log{
+		Level: logger.LevelSilent,
+		AddMsg: func(msg logger.Msg) {
+			if msg.Kind == logger.Error {
+				l.hasErrors = true
+			}
+			l.msgs = append(l.msgs, msg)
+		},
+		HasErrors: func() bool {
+			return l.hasErrors
+		},
+		Peek: func() []logger.Msg {
+			return append([]logger.Msg{}, l.msgs...)
+		},
+		Done: func() []logger.Msg {
+			sort.Stable(l.msgs)
+			return l.msgs
+		},
+	}
+	return l
+}
+
+// reset clears all recorded messages so the log can be used for another file.
+func (l *parseLog) reset() {
+	l.msgs = l.msgs[:0]
+	l.hasErrors = false
+}
+
+func parse(source string, filename string, opts Options, plog *parseLog) (result *Result, err error) {
+	if !opts.DisablePanicRecover {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				if len(stack) > maxPanicStackLen {
+					stack = stack[:maxPanicStackLen]
+				}
+				result = nil
+				err = &PanicError{Filename: filename, Value: r, Stack: string(stack)}
+			}
+		}()
+	}
+
+	source = decodeSource(source, opts.Encoding)
+
+	log := plog.log
 	src := logger.Source{
 		Contents:       source,
 		IdentifierName: filename,
 		KeyPath:        logger.Path{Text: filename},
 	}
 
-	tree, ok := js_parser.Parse(log, src, js_parser.Options{})
+	tree, ok := js_parser.Parse(log, src, parserOptions(opts.loaderFor(filename)))
 	if !ok {
 		msgs := log.Done()
 		if len(msgs) > 0 {
-			return nil, &ParseError{Messages: msgs}
+			// The log may be reused, so the error needs its own copy.
+			return nil, &ParseError{Messages: append([]logger.Msg{}, msgs...)}
 		}
 		return nil, &ParseError{}
 	}
@@ -58,29 +622,205 @@ func Parse(source string, filename string, opts Options) (*Result, error) {
 		opts:      opts,
 		exports:   make(map[string]struct{}),
 		reexports: make(map[string]struct{}),
+		requires:  make(map[string]struct{}),
+
+		reexportCasing: make(map[string]string),
+
+		exportsName: "exports",
+		moduleName:  "module",
+
+		resolvedRequires: make(map[string]struct{}),
+
+		definiteExports:   make(map[string]struct{}),
+		definiteReexports: make(map[string]struct{}),
+		sources:           make(map[string]ExportSource),
+		stats:             make(map[string]int),
 		// Track variable assignments: identifier ref -> what it holds
-		varRequire:              make(map[ast.Ref]string),    // var x = require("mod") -> ref(x) -> "mod"
-		varExports:              make(map[ast.Ref]struct{}),  // var e = exports -> ref(e) is alias of exports
-		varModExports:           make(map[ast.Ref]struct{}),  // var m = module.exports -> ref(m) is alias of module.exports
-		varObject:               make(map[ast.Ref]*objInfo),  // var o = { ... } -> ref(o) -> object info
-		varFunc:                 make(map[ast.Ref]*funcInfo), // function f() or var f = function/arrow -> ref(f) -> func info
-		nodeEnvAliases:          make(map[ast.Ref]struct{}),  // variables holding process.env.NODE_ENV value
+		varRequire:              make(map[ast.Ref]string),        // var x = require("mod") -> ref(x) -> "mod"
+		varRequireMember:        make(map[ast.Ref]ExportSource),  // const { a } = require("mod") -> ref(a) -> mod, a
+		varExports:              make(map[ast.Ref]struct{}),      // var e = exports -> ref(e) is alias of exports
+		varModExports:           make(map[ast.Ref]struct{}),      // var m = module.exports -> ref(m) is alias of module.exports
+		varModule:               make(map[ast.Ref]struct{}),      // (function(m) {...})(module) -> ref(m) is alias of module
+		varObject:               make(map[ast.Ref]*objInfo),      // var o = { ... } -> ref(o) -> object info
+		varFunc:                 make(map[ast.Ref]*funcInfo),     // function f() or var f = function/arrow -> ref(f) -> func info
+		varClass:                make(map[ast.Ref]*js_ast.Class), // class C {} or var C = class {} -> ref(C) -> class
+		exportStarAliases:       make(map[ast.Ref]struct{}),      // const { __exportStar: es } = require("tslib")
+		nodeEnvAliases:          make(map[ast.Ref]struct{}),      // variables holding process.env.NODE_ENV value
+		varConst:                make(map[ast.Ref]js_ast.Expr),   // const k = "name" -> ref(k) -> "name"
 		moduleExportsOverridden: false,
 	}
 
+	if len(opts.RequireLikeFunctions) > 0 {
+		w.requireLike = make(map[string]struct{}, len(opts.RequireLikeFunctions))
+		for _, name := range opts.RequireLikeFunctions {
+			w.requireLike[name] = struct{}{}
+		}
+	}
+	if len(opts.CopyPropsFunctions) > 0 {
+		w.copyProps = make(map[string]struct{}, len(opts.CopyPropsFunctions))
+		for _, name := range opts.CopyPropsFunctions {
+			w.copyProps[name] = struct{}{}
+		}
+	}
+	if opts.ExportsIdentifier != "" {
+		w.exportsName = opts.ExportsIdentifier
+	}
+	if opts.ModuleIdentifier != "" {
+		w.moduleName = opts.ModuleIdentifier
+	}
+
 	w.analyze()
+	if opts.ResolveReexport != nil && !opts.OnlyReexports {
+		w.expandReexports(filename)
+	}
 
 	// Check for annotation pattern: 0 && (module.exports = {...})
 	// esbuild's parser constant-folds this away, so we need a text scan.
 	w.scanAnnotationPattern(source, filename)
+	if opts.HonorExportDirectives {
+		w.scanExportDirectives(source)
+	}
+	// The TypeScript parser drops declarations, so they need a text scan too.
+	if opts.TSDeclare {
+		w.scanTSDeclarations(source)
+	}
+
+	// Sorting is skipped when only the counts or callbacks are needed
+	collect := !opts.CountOnly && (opts.CollectResult || (opts.OnExport == nil && opts.OnReexport == nil))
+	markConditional := opts.MarkConditional && !opts.CountOnly
+	sorted := collect || markConditional
+	var exports, reexports []string
+	if sorted {
+		exports, reexports = w.sortedExports(), w.sortedReexports()
+	}
+	if opts.OnExport != nil {
+		if sorted {
+			for _, name := range exports {
+				opts.OnExport(name)
+			}
+		} else {
+			for name := range w.exports {
+				opts.OnExport(name)
+			}
+		}
+	}
+	if opts.OnReexport != nil {
+		if sorted {
+			for _, path := range reexports {
+				opts.OnReexport(path)
+			}
+		} else {
+			for path := range w.reexports {
+				opts.OnReexport(path)
+			}
+		}
+	}
 
-	result := &Result{
-		Exports:   w.sortedExports(),
-		Reexports: w.sortedReexports(),
+	result = &Result{
+		ExportCount:       len(w.exports),
+		ReexportCount:     len(w.reexports),
+		HasDefault:        w.hasDefault(),
+		DefaultName:       w.defaultName,
+		HasUnknownExports: w.hasUnknownExports,
+	}
+	if collect {
+		result.Exports = exports
+		result.Reexports = reexports
+	}
+	if opts.CollectRequires {
+		result.Requires = w.sortedRequires()
+		result.ResolvedRequires = w.sortedResolvedRequires()
+	}
+	if opts.TrackExportSources && len(w.sources) > 0 {
+		result.ExportSources = w.sources
+	}
+	if len(w.reexportedNames) > 0 {
+		result.ReexportedNames = w.reexportedNames
+	}
+	if opts.CollectStats {
+		result.Stats = w.stats
+	}
+	if opts.CollectSourceMetrics {
+		result.ByteCount = len(source)
+		result.LineCount = strings.Count(source, "\n")
+		if source != "" && !strings.HasSuffix(source, "\n") {
+			result.LineCount++
+		}
+	}
+	if opts.ClassifyReexports && len(w.reexports) > 0 {
+		result.ReexportKinds = make(map[string]string, len(w.reexports))
+		for path := range w.reexports {
+			result.ReexportKinds[path] = classifySpecifier(path)
+		}
+	}
+	if markConditional {
+		result.ConditionalExports = conditionalNames(exports, w.definiteExports)
+		result.ConditionalReexports = conditionalNames(reexports, w.definiteReexports)
+	}
+	if opts.ReportUnknownPatterns {
+		// The walk does not strictly follow source order, so sort the
+		// warnings to keep the output stable for snapshot tests.
+		sort.SliceStable(w.warnings, func(i, j int) bool {
+			return w.warnings[i].Loc.Start < w.warnings[j].Loc.Start
+		})
+		result.Warnings = w.warnings
+	}
+	if opts.RetainAST {
+		result.AST = &tree
+		result.ModuleRef = w.resolveRef(tree.ModuleRef)
+		result.ExportsRef = w.resolveRef(tree.ExportsRef)
 	}
 	return result, nil
 }
 
+// maxPanicStackLen bounds the stack trace kept in a PanicError.
+const maxPanicStackLen = 4096
+
+// PanicError is returned when analysis panics, which can happen on AST shapes
+// the walker doesn't expect.
+type PanicError struct {
+	Filename string
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the beginning of the stack trace of the panic.
+	Stack string
+}
+
+// Error implements the error interface.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic while analyzing %s: %v", e.Filename, e.Value)
+}
+
+// loaderFor returns the loader to use for the given filename.
+func (opts *Options) loaderFor(filename string) Loader {
+	if opts.Loader != LoaderDefault {
+		return opts.Loader
+	}
+	ext := strings.ToLower(path.Ext(filename))
+	if loader, ok := opts.ExtensionLoaders[ext]; ok && loader != LoaderDefault {
+		return loader
+	}
+	if loader, ok := defaultExtensionLoaders[ext]; ok {
+		return loader
+	}
+	return LoaderJS
+}
+
+// parserOptions returns the parser options for a loader.
+func parserOptions(loader Loader) js_parser.Options {
+	var cfg config.Options
+	switch loader {
+	case LoaderJSX:
+		cfg.JSX.Parse = true
+	case LoaderTS:
+		cfg.TS.Parse = true
+	case LoaderTSX:
+		cfg.TS.Parse = true
+		cfg.JSX.Parse = true
+	}
+	return js_parser.OptionsFromConfig(&cfg)
+}
+
 // ParseError is returned when parsing fails.
 type ParseError struct {
 	Messages logger.SortableMsgs
@@ -97,12 +837,66 @@ func (e *ParseError) Error() string {
 // objInfo tracks object literal properties assigned to a variable.
 type objInfo struct {
 	props   map[string]struct{}
+	names   []string // props in the order they were added
 	spreads []string // require() paths spread into this object
+	// Objects spread into this one. Their properties are merged when read so
+	// that long chains of objects spreading each other stay linear.
+	parents []objParent
+}
+
+// objParent is an object spread into another. Only the first n of its names
+// existed when it was spread, so properties added later are not inherited.
+type objParent struct {
+	info *objInfo
+	n    int
+}
+
+func newObjInfo() *objInfo {
+	return &objInfo{props: make(map[string]struct{})}
+}
+
+// addProp records a property of the object.
+func (info *objInfo) addProp(name string) {
+	if _, ok := info.props[name]; !ok {
+		info.props[name] = struct{}{}
+		info.names = append(info.names, name)
+	}
+}
+
+// visit calls addProp for every property and addSpread for every spread
+// require() path, including those inherited from spread parents.
+func (info *objInfo) visit(addProp func(name string), addSpread func(path string)) {
+	// done holds how many names of each object were already visited
+	done := make(map[*objInfo]int)
+	stack := []objParent{{info: info, n: len(info.names)}}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		from, seen := done[cur.info]
+		if seen && from >= cur.n {
+			continue
+		}
+		done[cur.info] = cur.n
+		for _, name := range cur.info.names[from:cur.n] {
+			addProp(name)
+		}
+		if seen {
+			continue
+		}
+		if addSpread != nil {
+			for _, path := range cur.info.spreads {
+				addSpread(path)
+			}
+		}
+		stack = append(stack, cur.info.parents...)
+	}
 }
 
 // funcInfo tracks function bodies for call-mode analysis.
 type funcInfo struct {
 	body []js_ast.Stmt
+	// name is the function's own name, e.g. Foo for var f = function Foo() {}
+	name string
 }
 
 // walker walks the AST to detect CJS exports.
@@ -111,18 +905,64 @@ type walker struct {
 	opts      Options
 	exports   map[string]struct{}
 	reexports map[string]struct{}
+	requires  map[string]struct{}
+	// require.resolve() paths, kept apart from requires
+	resolvedRequires map[string]struct{}
+	sources          map[string]ExportSource
+	reexportedNames  map[string][]string
+	stats            map[string]int
+
+	warnings          []Warning
+	hasUnknownExports bool
+
+	// Function names from Options.RequireLikeFunctions and
+	// Options.CopyPropsFunctions
+	requireLike map[string]struct{}
+	copyProps   map[string]struct{}
+
+	// Identifier names of the exports and module objects
+	exportsName string
+	moduleName  string
+
+	// Nesting depth of .call(this) factories, where this.exports and
+	// this.module stand for exports and module
+	thisContextDepth int
+
+	// Lowercased reexport path -> first-seen casing, for
+	// Options.CaseInsensitiveReexports
+	reexportCasing map[string]string
+
+	// Importer and reexport path -> Options.ResolveReexport result, with a
+	// nil result when unresolved, so modules reachable through several
+	// reexports are resolved once
+	resolved map[string]resolvedReexport
+
+	// Exports and reexports found outside of any try or catch block, and the
+	// current try/catch nesting depth. Used for Options.MarkConditional.
+	definiteExports   map[string]struct{}
+	definiteReexports map[string]struct{}
+	conditionalDepth  int
 
 	// Variable tracking maps
-	varRequire     map[ast.Ref]string    // ref -> require path
-	varExports     map[ast.Ref]struct{}  // refs that alias `exports`
-	varModExports  map[ast.Ref]struct{}  // refs that alias `module.exports`
-	varObject      map[ast.Ref]*objInfo  // refs -> object literal info
-	varFunc        map[ast.Ref]*funcInfo // refs -> function body info
-	nodeEnvAliases map[ast.Ref]struct{}  // refs that hold process.env.NODE_ENV
+	varRequire        map[ast.Ref]string        // ref -> require path
+	varRequireMember  map[ast.Ref]ExportSource  // ref -> destructured require member
+	varExports        map[ast.Ref]struct{}      // refs that alias `exports`
+	varModExports     map[ast.Ref]struct{}      // refs that alias `module.exports`
+	varModule         map[ast.Ref]struct{}      // refs that alias `module`
+	varObject         map[ast.Ref]*objInfo      // refs -> object literal info
+	varFunc           map[ast.Ref]*funcInfo     // refs -> function body info
+	varClass          map[ast.Ref]*js_ast.Class // refs -> class declarations
+	exportStarAliases map[ast.Ref]struct{}      // refs bound to a destructured __exportStar
+	nodeEnvAliases    map[ast.Ref]struct{}      // refs that hold process.env.NODE_ENV
+	varConst          map[ast.Ref]js_ast.Expr   // const refs -> string or number literal
 
 	// When module.exports = something is encountered, prior exports.X assignments
 	// are invalidated.
 	moduleExportsOverridden bool
+	// Set when the value assigned to module.exports acts as the default export.
+	defaultIsModuleExports bool
+	// Name of the value assigned to module.exports, if any.
+	defaultName string
 }
 
 // analyze runs the full analysis pass.
@@ -138,6 +978,81 @@ func (w *walker) analyze() {
 	}
 }
 
+// defaultMaxReexportDepth is the MaxReexportDepth used when it is unset.
+const defaultMaxReexportDepth = 8
+
+// resolvedReexport is a module returned by Options.ResolveReexport.
+type resolvedReexport struct {
+	name   string
+	result *Result
+}
+
+// expandReexports merges the names of resolved reexports into the exports.
+// importer is the filename of the analyzed module.
+func (w *walker) expandReexports(importer string) {
+	local := make(map[string]struct{}, len(w.exports))
+	for name := range w.exports {
+		local[name] = struct{}{}
+	}
+	maxDepth := w.opts.MaxReexportDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxReexportDepth
+	}
+	w.reexportedNames = make(map[string][]string)
+	w.resolved = make(map[string]resolvedReexport)
+	for _, path := range w.sortedReexports() {
+		w.expandReexport(path, importer, path, local, map[string]struct{}{}, 1, maxDepth)
+	}
+}
+
+// expandReexport merges the names resolved for path, reexported by importer,
+// and for the reexports of its result in turn, into the exports as forwarded
+// from the top-level reexport via. stack holds the resolved names of the
+// modules being expanded to detect cycles.
+func (w *walker) expandReexport(via string, importer string, path string, local map[string]struct{}, stack map[string]struct{}, depth int, maxDepth int) {
+	if depth > maxDepth {
+		w.warnings = append(w.warnings, Warning{Text: fmt.Sprintf("reexport depth limit %d reached at %q", maxDepth, path)})
+		return
+	}
+	key := importer + "\x00" + path
+	entry, cached := w.resolved[key]
+	if !cached {
+		if name, res, ok := w.opts.ResolveReexport(importer, path); ok && res != nil {
+			entry = resolvedReexport{name: name, result: res}
+		}
+		w.resolved[key] = entry
+	}
+	res := entry.result
+	if res == nil {
+		return
+	}
+	if _, ok := stack[entry.name]; ok {
+		w.warnings = append(w.warnings, Warning{Text: fmt.Sprintf("reexport cycle through %q", path)})
+		return
+	}
+	for _, name := range res.Exports {
+		if _, isLocal := local[name]; isLocal {
+			// The local definition shadows the reexported one
+			continue
+		}
+		if _, ok := w.exports[name]; !ok {
+			// Names are attributed to the first reexport providing them
+			w.reexportedNames[via] = append(w.reexportedNames[via], name)
+		}
+		w.addExport(name)
+		if w.opts.TrackExportSources {
+			if _, ok := w.sources[name]; !ok {
+				w.sources[name] = ExportSource{Path: via, Member: name}
+			}
+		}
+	}
+	stack[entry.name] = struct{}{}
+	for _, nested := range res.Reexports {
+		w.expandReexport(via, entry.name, nested, local, stack, depth+1, maxDepth)
+	}
+	delete(stack, entry.name)
+}
+
 // collectVarDecls scans for variable declarations to track aliases.
 func (w *walker) collectVarDecls(stmts []js_ast.Stmt) {
 	for _, stmt := range stmts {
@@ -145,6 +1060,9 @@ func (w *walker) collectVarDecls(stmts []js_ast.Stmt) {
 		case *js_ast.SLocal:
 			for _, decl := range s.Decls {
 				w.collectDecl(decl)
+				if s.Kind == js_ast.LocalConst {
+					w.collectConstDecl(decl)
+				}
 			}
 		case *js_ast.SBlock:
 			w.collectVarDecls(s.Stmts)
@@ -153,13 +1071,38 @@ func (w *walker) collectVarDecls(stmts []js_ast.Stmt) {
 			if s.NoOrNil.Data != nil {
 				w.collectVarDeclsFromStmt(s.NoOrNil)
 			}
+		case *js_ast.STry:
+			w.collectVarDecls(s.Block.Stmts)
+			if s.Catch != nil {
+				w.collectVarDecls(s.Catch.Block.Stmts)
+			}
+			if s.Finally != nil {
+				w.collectVarDecls(s.Finally.Block.Stmts)
+			}
 		case *js_ast.SExpr:
 			// Handle IIFE: (function(){...})() or (() => {...})()
 			w.collectVarDeclsFromExpr(s.Value)
+		case *js_ast.SClass:
+			if s.Class.Name != nil {
+				w.varClass[w.resolveRef(s.Class.Name.Ref)] = &s.Class
+			}
 		}
 	}
 }
 
+// collectConstDecl records const bindings of string and number literals,
+// which may be used as computed keys like exports[KEY].
+func (w *walker) collectConstDecl(decl js_ast.Decl) {
+	id, ok := decl.Binding.Data.(*js_ast.BIdentifier)
+	if !ok || decl.ValueOrNil.Data == nil {
+		return
+	}
+	switch decl.ValueOrNil.Data.(type) {
+	case *js_ast.EString, *js_ast.ENumber:
+		w.varConst[w.resolveRef(id.Ref)] = decl.ValueOrNil
+	}
+}
+
 // collectVarDeclsFromStmt unwraps a single statement for var decl collection.
 func (w *walker) collectVarDeclsFromStmt(stmt js_ast.Stmt) {
 	switch s := stmt.Data.(type) {
@@ -180,6 +1123,27 @@ func (w *walker) collectVarDeclsFromExpr(expr js_ast.Expr) {
 	case *js_ast.ECall:
 		w.collectVarDeclsFromCallTarget(e)
 	case *js_ast.EBinary:
+		// Handle: o = { ... } where o was declared earlier
+		if e.Op == js_ast.BinOpAssign {
+			if id, ok := e.Left.Data.(*js_ast.EIdentifier); ok {
+				if obj, ok := e.Right.Data.(*js_ast.EObject); ok {
+					info := newObjInfo()
+					w.extractObjectProps(obj, info)
+					w.varObject[w.resolveRef(id.Ref)] = info
+					return
+				}
+				// _default = function Foo() {} (hoisted by Babel)
+				if fn, ok := e.Right.Data.(*js_ast.EFunction); ok {
+					w.varFunc[w.resolveRef(id.Ref)] = w.newFuncInfo(fn)
+					return
+				}
+				// _default = require("x") where _default was declared earlier
+				if path, ok := w.extractRequire(e.Right); ok {
+					w.varRequire[w.resolveRef(id.Ref)] = path
+					return
+				}
+			}
+		}
 		// Handle: expr && (function(){...})(), expr || (function(){...})()
 		w.collectVarDeclsFromExpr(e.Left)
 		w.collectVarDeclsFromExpr(e.Right)
@@ -218,6 +1182,34 @@ func (w *walker) collectVarDeclsFromCallTarget(call *js_ast.ECall) {
 	}
 }
 
+// newFuncInfo returns the info tracked for a function expression.
+func (w *walker) newFuncInfo(fn *js_ast.EFunction) *funcInfo {
+	info := &funcInfo{body: fn.Fn.Body.Block.Stmts}
+	if fn.Fn.Name != nil {
+		info.name = w.symbolName(fn.Fn.Name.Ref)
+	}
+	return info
+}
+
+// assignChainRefs unwraps b = c = value into the assigned identifier refs and
+// the final value. It stops at the first non-identifier assignment target.
+func (w *walker) assignChainRefs(expr js_ast.Expr) ([]ast.Ref, js_ast.Expr) {
+	var refs []ast.Ref
+	for {
+		bin, ok := expr.Data.(*js_ast.EBinary)
+		if !ok || bin.Op != js_ast.BinOpAssign {
+			break
+		}
+		id, ok := bin.Left.Data.(*js_ast.EIdentifier)
+		if !ok {
+			break
+		}
+		refs = append(refs, w.resolveRef(id.Ref))
+		expr = bin.Right
+	}
+	return refs, expr
+}
+
 // collectDecl processes a single variable declaration.
 func (w *walker) collectDecl(decl js_ast.Decl) {
 	if decl.ValueOrNil.Data == nil {
@@ -235,6 +1227,22 @@ func (w *walker) collectDecl(decl js_ast.Decl) {
 			return
 		}
 
+		// var a = b = c = exports registers every binding in the chain
+		if aliases, target := w.assignChainRefs(val); len(aliases) > 0 {
+			if w.isExportsRef(target) {
+				for _, alias := range append(aliases, ref) {
+					w.varExports[alias] = struct{}{}
+				}
+				return
+			}
+			if w.isModuleExportsAccess(target) {
+				for _, alias := range append(aliases, ref) {
+					w.varModExports[alias] = struct{}{}
+				}
+				return
+			}
+		}
+
 		// var e = exports
 		if w.isExportsRef(val) {
 			w.varExports[ref] = struct{}{}
@@ -253,7 +1261,7 @@ func (w *walker) collectDecl(decl js_ast.Decl) {
 				// Track as module.exports alias so x.foo = ... adds exports
 				w.varModExports[ref] = struct{}{}
 				if obj, ok := bin.Right.Data.(*js_ast.EObject); ok {
-					info := &objInfo{props: make(map[string]struct{})}
+					info := newObjInfo()
 					w.extractObjectProps(obj, info)
 					w.varObject[ref] = info
 				}
@@ -262,7 +1270,7 @@ func (w *walker) collectDecl(decl js_ast.Decl) {
 
 		// var o = { ... }
 		if obj, ok := val.Data.(*js_ast.EObject); ok {
-			info := &objInfo{props: make(map[string]struct{})}
+			info := newObjInfo()
 			w.extractObjectProps(obj, info)
 			w.varObject[ref] = info
 			return
@@ -270,7 +1278,7 @@ func (w *walker) collectDecl(decl js_ast.Decl) {
 
 		// var f = function() {} or var f = () => {}
 		if fn, ok := val.Data.(*js_ast.EFunction); ok {
-			w.varFunc[ref] = &funcInfo{body: fn.Fn.Body.Block.Stmts}
+			w.varFunc[ref] = w.newFuncInfo(fn)
 			return
 		}
 		if fn, ok := val.Data.(*js_ast.EArrow); ok {
@@ -278,6 +1286,14 @@ func (w *walker) collectDecl(decl js_ast.Decl) {
 			return
 		}
 
+		// var C = class {} (wrapped in a purity annotation by the parser)
+		if annotation, ok := val.Data.(*js_ast.EAnnotation); ok {
+			if class, ok := annotation.Value.Data.(*js_ast.EClass); ok {
+				w.varClass[ref] = &class.Class
+				return
+			}
+		}
+
 		// var x = process.env.NODE_ENV
 		if w.isProcessEnvNodeEnv(val) {
 			w.nodeEnvAliases[ref] = struct{}{}
@@ -285,6 +1301,24 @@ func (w *walker) collectDecl(decl js_ast.Decl) {
 		}
 
 	case *js_ast.BObject:
+		// const { __exportStar: es } = require("tslib")
+		// const { a, b: c } = require("./x")
+		if path, ok := w.extractRequire(decl.ValueOrNil); ok {
+			for _, prop := range b.Properties {
+				id, ok := prop.Value.Data.(*js_ast.BIdentifier)
+				if !ok {
+					continue
+				}
+				key := w.exprToString(prop.Key)
+				if key == "__exportStar" {
+					w.exportStarAliases[w.resolveRef(id.Ref)] = struct{}{}
+				}
+				if key != "" {
+					w.varRequireMember[w.resolveRef(id.Ref)] = ExportSource{Path: path, Member: key}
+				}
+			}
+		}
+
 		// const { NODE_ENV } = process.env
 		// const { NODE_ENV: alias } = process.env
 		if w.isProcessEnv(decl.ValueOrNil) {
@@ -309,6 +1343,9 @@ func (w *walker) walkStmts(stmts []js_ast.Stmt) {
 
 // walkStmt processes a single statement.
 func (w *walker) walkStmt(stmt js_ast.Stmt) {
+	if w.opts.IncludeESMExports {
+		w.walkESMExport(stmt)
+	}
 	switch s := stmt.Data.(type) {
 	case *js_ast.SExpr:
 		w.walkExpr(s.Value)
@@ -324,47 +1361,179 @@ func (w *walker) walkStmt(stmt js_ast.Stmt) {
 		w.walkStmts(s.Stmts)
 	case *js_ast.SIf:
 		w.walkIfStmt(s)
+	case *js_ast.STry:
+		// The try and catch blocks may not run to completion, so whatever
+		// they export is conditional. The finally block always runs.
+		w.conditionalDepth++
+		w.walkStmts(s.Block.Stmts)
+		if s.Catch != nil {
+			w.walkStmts(s.Catch.Block.Stmts)
+		}
+		w.conditionalDepth--
+		if s.Finally != nil {
+			w.walkStmts(s.Finally.Block.Stmts)
+		}
 	case *js_ast.SFunction:
 		// function Foo() {} -- track it
 		if s.Fn.Body.Block.Stmts != nil {
 			w.varFunc[w.resolveRef(s.Fn.Name.Ref)] = &funcInfo{body: s.Fn.Body.Block.Stmts}
 		}
+	case *js_ast.SClass:
+		w.walkClassStaticBlocks(&s.Class)
+	case *js_ast.SForOf:
+		w.walkForOfStmt(s)
 	}
 }
 
-// walkExpr processes an expression for export patterns.
-func (w *walker) walkExpr(expr js_ast.Expr) {
-	switch e := expr.Data.(type) {
-	case *js_ast.EBinary:
-		w.walkBinaryExpr(e)
-	case *js_ast.ECall:
-		w.walkCallExpr(e)
-	case *js_ast.EUnary:
-		// Handle: !function(){...}()
-		w.walkExpr(e.Value)
+// walkForOfStmt walks the body of a loop over a static array of names, like
+// for (const name of ["a", "b"]) { exports[name] = impl[name]; }, once per
+// name with the loop variable bound to it. Other loops are skipped.
+func (w *walker) walkForOfStmt(s *js_ast.SForOf) {
+	local, ok := s.Init.Data.(*js_ast.SLocal)
+	if !ok || len(local.Decls) != 1 {
+		return
 	}
-}
-
-// walkBinaryExpr processes binary expressions.
-func (w *walker) walkBinaryExpr(e *js_ast.EBinary) {
-	switch e.Op {
-	case js_ast.BinOpAssign:
-		w.checkExportAssignment(e.Left, e.Right)
-		// Also recurse into RHS for chained assignments and nested patterns
-		w.walkExpr(e.Right)
-
-	case js_ast.BinOpLogicalAnd:
-		// Pattern: 0 && (module.exports = {...})  -- annotation pattern
-		if w.isFalsyLiteral(e.Left) {
-			w.walkAnnotationExpr(e.Right)
+	id, ok := local.Decls[0].Binding.Data.(*js_ast.BIdentifier)
+	if !ok {
+		return
+	}
+	arr, ok := s.Value.Data.(*js_ast.EArray)
+	if !ok {
+		return
+	}
+	for _, item := range arr.Items {
+		if _, ok := item.Data.(*js_ast.EString); !ok {
 			return
 		}
-		// Pattern: "production" !== process.env.NODE_ENV && (function(){...})()
-		if w.opts.NodeEnv != "" {
-			if w.evaluateNodeEnvCondition(e.Left) {
-				w.walkExpr(e.Right)
-			}
-			return
+	}
+	ref := w.resolveRef(id.Ref)
+	for _, item := range arr.Items {
+		w.varConst[ref] = item
+		w.walkStmtBody(s.Body)
+	}
+	delete(w.varConst, ref)
+}
+
+// walkESMExport records the names declared by an ES module export statement.
+func (w *walker) walkESMExport(stmt js_ast.Stmt) {
+	switch s := stmt.Data.(type) {
+	case *js_ast.SExportDefault:
+		// export default function Foo() {} or export default class Bar {}
+		w.addExport("default")
+		switch v := s.Value.Data.(type) {
+		case *js_ast.SFunction:
+			if v.Fn.Name != nil {
+				w.defaultName = w.symbolName(v.Fn.Name.Ref)
+			}
+		case *js_ast.SClass:
+			if v.Class.Name != nil {
+				w.defaultName = w.symbolName(v.Class.Name.Ref)
+			}
+		case *js_ast.SExpr:
+			w.defaultName = w.defaultNameOf(v.Value)
+		}
+	case *js_ast.SLocal:
+		if s.IsExport {
+			for _, decl := range s.Decls {
+				w.addBindingExports(decl.Binding)
+			}
+		}
+	case *js_ast.SFunction:
+		if s.IsExport && s.Fn.Name != nil {
+			w.addExport(w.symbolName(s.Fn.Name.Ref))
+		}
+	case *js_ast.SClass:
+		if s.IsExport && s.Class.Name != nil {
+			w.addExport(w.symbolName(s.Class.Name.Ref))
+		}
+	case *js_ast.SExportClause:
+		for _, item := range s.Items {
+			w.addExport(item.Alias)
+		}
+	case *js_ast.SExportFrom:
+		for _, item := range s.Items {
+			w.addExport(item.Alias)
+		}
+	case *js_ast.SExportStar:
+		// export * as ns from "mod" exports a single name
+		if s.Alias != nil {
+			w.addExport(s.Alias.OriginalName)
+		} else {
+			w.addReexport(w.tree.ImportRecords[s.ImportRecordIndex].Path.Text)
+		}
+	}
+}
+
+// addBindingExports records the names bound by an exported declaration,
+// e.g. a and b for export const { a, b: [b] } = obj.
+func (w *walker) addBindingExports(binding js_ast.Binding) {
+	switch b := binding.Data.(type) {
+	case *js_ast.BIdentifier:
+		w.addExport(w.symbolName(b.Ref))
+	case *js_ast.BArray:
+		for _, item := range b.Items {
+			w.addBindingExports(item.Binding)
+		}
+	case *js_ast.BObject:
+		for _, prop := range b.Properties {
+			w.addBindingExports(prop.Value)
+		}
+	}
+}
+
+// walkClassStaticBlocks walks the static {} blocks of a class, which run when
+// the class is evaluated.
+func (w *walker) walkClassStaticBlocks(class *js_ast.Class) {
+	for _, prop := range class.Properties {
+		if prop.Kind == js_ast.PropertyClassStaticBlock && prop.ClassStaticBlock != nil {
+			w.walkStmts(prop.ClassStaticBlock.Block.Stmts)
+		}
+	}
+}
+
+// walkExpr processes an expression for export patterns.
+func (w *walker) walkExpr(expr js_ast.Expr) {
+	switch e := expr.Data.(type) {
+	case *js_ast.EBinary:
+		w.walkBinaryExpr(e)
+	case *js_ast.ECall:
+		w.walkCallExpr(e)
+	case *js_ast.EUnary:
+		// Handle: !function(){...}()
+		w.walkExpr(e.Value)
+	case *js_ast.EDot:
+		// Handle: require("mod").foo
+		w.walkExpr(e.Target)
+	case *js_ast.EIndex:
+		w.walkExpr(e.Target)
+		w.walkExpr(e.Index)
+	case *js_ast.EAnnotation:
+		w.walkExpr(e.Value)
+	case *js_ast.EClass:
+		// Handle: var C = class { static { ... } }
+		w.walkClassStaticBlocks(&e.Class)
+	}
+}
+
+// walkBinaryExpr processes binary expressions.
+func (w *walker) walkBinaryExpr(e *js_ast.EBinary) {
+	switch e.Op {
+	case js_ast.BinOpAssign:
+		w.checkExportAssignment(e.Left, e.Right)
+		// Also recurse into RHS for chained assignments and nested patterns
+		w.walkExpr(e.Right)
+
+	case js_ast.BinOpLogicalAnd:
+		// Pattern: 0 && (module.exports = {...})  -- annotation pattern
+		if w.isFalsyLiteral(e.Left) {
+			w.walkAnnotationExpr(e.Right)
+			return
+		}
+		// Pattern: "production" !== process.env.NODE_ENV && (function(){...})()
+		// Only skip the RHS when the guard is known to be false, so that
+		// guards like module?.exports && (module.exports.foo = 1) still walk.
+		if w.opts.NodeEnv != "" && w.evaluateCondition(e.Left) == condFalse {
+			return
 		}
 		w.walkExpr(e.Right)
 
@@ -373,6 +1542,11 @@ func (w *walker) walkBinaryExpr(e *js_ast.EBinary) {
 		w.walkExpr(e.Left)
 		w.walkExpr(e.Right)
 
+	case js_ast.BinOpLogicalOrAssign, js_ast.BinOpNullishCoalescingAssign:
+		// Pattern: module.exports ||= {} never replaces the existing object.
+		w.walkExpr(e.Left)
+		w.walkExpr(e.Right)
+
 	case js_ast.BinOpLooseNe, js_ast.BinOpStrictNe:
 		// These show up in NODE_ENV checks; handled by parent context.
 
@@ -398,21 +1572,37 @@ func (w *walker) walkAnnotationExpr(expr js_ast.Expr) {
 
 // walkCallExpr processes function call expressions.
 func (w *walker) walkCallExpr(call *js_ast.ECall) {
+	// require("mod") evaluated for its side effects
+	if _, ok := w.extractRequire(js_ast.Expr{Data: call}); ok {
+		return
+	}
+
+	// require.resolve("mod") only looks up a path and never loads the module
+	if path, ok := w.extractRequireResolve(call); ok {
+		if w.opts.CollectRequires {
+			w.resolvedRequires[path] = struct{}{}
+		}
+		return
+	}
+
 	// Object.defineProperty(exports, "name", { ... })
 	if w.isObjectDefineProperty(call) {
+		w.countPattern("defineProperty")
 		w.handleDefineProperty(call)
 		return
 	}
 
 	// Object.defineProperty(module, "exports", { value: {...} })
 	if w.isModuleDefineProperty(call) {
+		w.countPattern("moduleDefineProperty")
 		w.handleModuleDefineProperty(call)
 		return
 	}
 
-	// Object.assign(module.exports, {...}, ...)
+	// Object.assign(module.exports, {...}, ...) or Object.assign(exports, {...}, ...)
 	if w.isObjectAssign(call) && len(call.Args) >= 2 {
-		if w.isModuleExportsAccess(call.Args[0]) {
+		if w.isExportsTarget(call.Args[0]) {
+			w.countPattern("objectAssign")
 			w.handleObjectAssignToModuleExports(call.Args[1:])
 			return
 		}
@@ -421,6 +1611,7 @@ func (w *walker) walkCallExpr(call *js_ast.ECall) {
 	// Object.assign(module, { exports: {...} })
 	if w.isObjectAssign(call) && len(call.Args) >= 2 {
 		if w.isModuleRef(call.Args[0]) {
+			w.countPattern("objectAssignModule")
 			w.handleObjectAssignToModule(call.Args[1:])
 			return
 		}
@@ -429,30 +1620,76 @@ func (w *walker) walkCallExpr(call *js_ast.ECall) {
 	// __exportStar({...}, exports) or require("tslib").__exportStar({...}, exports)
 	// or (0, tslib.__exportStar)({...}, exports) or (0, __exportStar)({...}, exports)
 	if w.isExportStarCall(call) {
+		w.countPattern("exportStar")
 		w.handleExportStarCall(call)
 		return
 	}
 
+	// copyProps(exports, require("./util")) for helpers in Options.CopyPropsFunctions
+	if w.isCopyPropsCall(call) {
+		w.countPattern("copyProps")
+		w.handleObjectAssignToModuleExports(call.Args[1:])
+		return
+	}
+
+	// __webpack_require__.d(__webpack_exports__, {...}) or __webpack_require__.r(__webpack_exports__)
+	if method := w.webpackRuntimeMethod(call); method != "" {
+		w.countPattern("webpackRuntime")
+		w.handleWebpackRuntimeCall(method, call)
+		return
+	}
+
 	// __export({...}) or __export(require("..."))
 	if w.isExportCall(call) {
+		w.countPattern("export")
 		w.handleExportCall(call)
 		return
 	}
 
+	// _export(exports, { foo: function() { return foo; } }) (@babel/runtime)
+	if w.isBabelExportCall(call) {
+		w.countPattern("export")
+		w.handleExportCall(&js_ast.ECall{Args: call.Args[1:]})
+		return
+	}
+
 	// IIFE: (function(){...})() or (() => {...})()
 	var body []js_ast.Stmt
 	switch fn := call.Target.Data.(type) {
 	case *js_ast.EFunction:
+		w.mapWrapperParams(fn.Fn.Args, call.Args)
 		body = fn.Fn.Body.Block.Stmts
 	case *js_ast.EArrow:
+		w.mapWrapperParams(fn.Args, call.Args)
 		body = fn.Body.Block.Stmts
 	case *js_ast.EDot:
 		// (function(){}).call(this)
 		if fn.Name == "call" || fn.Name == "apply" {
+			// The arguments after this are passed as the parameters
+			var args []js_ast.Expr
+			if fn.Name == "call" && len(call.Args) > 1 {
+				args = call.Args[1:]
+			} else if fn.Name == "apply" && len(call.Args) > 1 {
+				if arr, ok := call.Args[1].Data.(*js_ast.EArray); ok {
+					args = arr.Items
+				}
+			}
 			switch inner := fn.Target.Data.(type) {
 			case *js_ast.EFunction:
+				w.mapWrapperParams(inner.Fn.Args, args)
+				// Module system shims pass the CJS context as this, so
+				// this.exports and this.module refer to exports and module
+				if len(call.Args) > 0 {
+					if _, ok := call.Args[0].Data.(*js_ast.EThis); ok {
+						w.thisContextDepth++
+						w.walkStmts(inner.Fn.Body.Block.Stmts)
+						w.thisContextDepth--
+						return
+					}
+				}
 				body = inner.Fn.Body.Block.Stmts
 			case *js_ast.EArrow:
+				w.mapWrapperParams(inner.Args, args)
 				body = inner.Body.Block.Stmts
 			}
 		}
@@ -471,22 +1708,40 @@ func (w *walker) walkCallExpr(call *js_ast.ECall) {
 
 // checkExportAssignment checks if an assignment targets exports.
 func (w *walker) checkExportAssignment(left js_ast.Expr, right js_ast.Expr) {
+	// [exports.a, exports.b] = value or ({ a: exports.a } = value)
+	switch left.Data.(type) {
+	case *js_ast.EArray, *js_ast.EObject:
+		w.countPattern("destructuring")
+		w.checkDestructuringAssignment(left)
+		return
+	}
+
 	// exports.foo = value
 	if name, ok := w.getExportsPropertyName(left); ok {
+		w.countPattern("exportsProperty")
 		if !w.moduleExportsOverridden {
-			w.addExport(name)
+			w.addExportFrom(name, right)
+		} else if w.opts.ReportUnknownPatterns {
+			// exports no longer points at module.exports, so this is
+			// almost certainly a bug in the module
+			w.warnings = append(w.warnings, Warning{
+				Text: fmt.Sprintf("exports.%s is assigned after module.exports was replaced and is not exported", name),
+				Loc:  left.Loc,
+			})
 		}
 		return
 	}
 
 	// module.exports.foo = value (always add, even after override)
 	if name, ok := w.getModuleExportsPropertyName(left); ok {
-		w.addExport(name)
+		w.countPattern("moduleExportsProperty")
+		w.addExportFrom(name, right)
 		return
 	}
 
 	// module.exports = value
 	if w.isModuleExportsAccess(left) {
+		w.countPattern("moduleExportsAssign")
 		w.handleModuleExportsAssignment(right)
 		return
 	}
@@ -497,17 +1752,19 @@ func (w *walker) checkExportAssignment(left js_ast.Expr, right js_ast.Expr) {
 			ref := w.resolveRef(id.Ref)
 			// Check if target is exports alias
 			if _, isAlias := w.varExports[ref]; isAlias {
-				w.addExport(dot.Name)
+				w.countPattern("aliasProperty")
+				w.addExportFrom(dot.Name, right)
 				return
 			}
 			// Check if target is module.exports alias
 			if _, isAlias := w.varModExports[ref]; isAlias {
-				w.addExport(dot.Name)
+				w.countPattern("aliasProperty")
+				w.addExportFrom(dot.Name, right)
 				return
 			}
 			// Check if target is a tracked object variable
 			if info, ok := w.varObject[ref]; ok {
-				info.props[dot.Name] = struct{}{}
+				info.addProp(dot.Name)
 				return
 			}
 			// Check if target is a require()'d module
@@ -520,15 +1777,17 @@ func (w *walker) checkExportAssignment(left js_ast.Expr, right js_ast.Expr) {
 
 	// alias["foo"] = value
 	if idx, ok := left.Data.(*js_ast.EIndex); ok {
-		if name := w.exprToString(idx.Index); name != "" {
+		if name := w.indexKey(idx.Index); name != "" {
 			if id, ok := idx.Target.Data.(*js_ast.EIdentifier); ok {
 				ref := w.resolveRef(id.Ref)
 				if _, isAlias := w.varExports[ref]; isAlias {
-					w.addExport(name)
+					w.countPattern("aliasProperty")
+					w.addExportFrom(name, right)
 					return
 				}
 				if _, isAlias := w.varModExports[ref]; isAlias {
-					w.addExport(name)
+					w.countPattern("aliasProperty")
+					w.addExportFrom(name, right)
 					return
 				}
 			}
@@ -536,15 +1795,152 @@ func (w *walker) checkExportAssignment(left js_ast.Expr, right js_ast.Expr) {
 	}
 }
 
+// checkDestructuringAssignment checks each target of an assignment pattern.
+// The values come from destructuring, so no export sources are recorded.
+func (w *walker) checkDestructuringAssignment(target js_ast.Expr) {
+	switch t := target.Data.(type) {
+	case *js_ast.EArray:
+		for _, item := range t.Items {
+			w.checkDestructuringAssignment(item)
+		}
+	case *js_ast.EObject:
+		for _, prop := range t.Properties {
+			if prop.ValueOrNil.Data != nil {
+				w.checkDestructuringAssignment(prop.ValueOrNil)
+			}
+		}
+	case *js_ast.ESpread:
+		// [...exports.rest] = value
+		w.checkDestructuringAssignment(t.Value)
+	case *js_ast.EBinary:
+		// [exports.a = 1] = value
+		if t.Op == js_ast.BinOpAssign {
+			w.checkDestructuringAssignment(t.Left)
+		}
+	case *js_ast.EMissing:
+	default:
+		w.checkExportAssignment(target, js_ast.Expr{})
+	}
+}
+
 // handleModuleExportsAssignment processes module.exports = <value>.
 func (w *walker) handleModuleExportsAssignment(value js_ast.Expr) {
-	w.moduleExportsOverridden = true
-	w.exports = make(map[string]struct{})
-	w.reexports = make(map[string]struct{})
+	// module.exports = (require("./polyfill"), {...})
+	// module.exports = void require("./polyfill") || {...}
+	// Only the last operand is exported, but earlier ones still run.
+	rebindsExports := false
+	for {
+		// module.exports = Object.freeze({...}) has the same keys
+		if call, ok := value.Data.(*js_ast.ECall); ok && w.isObjectFreeze(call) {
+			value = call.Args[0]
+			continue
+		}
+		bin, ok := value.Data.(*js_ast.EBinary)
+		if !ok {
+			break
+		}
+		if bin.Op == js_ast.BinOpComma {
+			w.walkExpr(bin.Left)
+			value = bin.Right
+			continue
+		}
+		// module.exports = global.MyLib = {...} also exposes the value as a
+		// global, but only the final value matters here
+		if bin.Op == js_ast.BinOpAssign {
+			if w.isExportsRef(bin.Left) {
+				rebindsExports = true
+			}
+			value = bin.Right
+			continue
+		}
+		if unary, ok := bin.Left.Data.(*js_ast.EUnary); ok && bin.Op == js_ast.BinOpLogicalOr && unary.Op == js_ast.UnOpVoid {
+			w.walkExpr(bin.Left)
+			value = bin.Right
+			continue
+		}
+		break
+	}
+
+	// module.exports = exports (or an alias of it) keeps the same object
+	if w.isExportsAlias(value) {
+		return
+	}
+
+	// module.exports = module.exports || {} keeps the existing object, which
+	// is always present in CommonJS, so this is not an override.
+	if bin, ok := value.Data.(*js_ast.EBinary); ok && (bin.Op == js_ast.BinOpLogicalOr || bin.Op == js_ast.BinOpNullishCoalescing) {
+		if w.isExportsTarget(bin.Left) || w.isExportsAlias(bin.Left) {
+			w.walkExpr(bin.Right)
+			return
+		}
+	}
+
+	// module.exports = Object.assign(module.exports, require("./a"), ...)
+	// This augments the existing exports object rather than replacing it.
+	if call, ok := value.Data.(*js_ast.ECall); ok && w.isObjectAssign(call) && len(call.Args) >= 2 {
+		if w.isExportsTarget(call.Args[0]) {
+			w.handleObjectAssignToModuleExports(call.Args[1:])
+			return
+		}
+	}
+
+	w.overrideModuleExports()
+	// module.exports = exports = {...} keeps exports pointing at the new object
+	if rebindsExports {
+		w.moduleExportsOverridden = false
+	}
+	w.defaultName = w.defaultNameOf(value)
+	w.handleModuleExportsValue(value)
+	if w.opts.DefaultExportName != "" && w.isNonObjectValue(value) {
+		w.addExport(w.opts.DefaultExportName)
+	}
+}
+
+// isNonObjectValue checks for module.exports values that are known not to be
+// plain objects: functions, classes, and primitives.
+func (w *walker) isNonObjectValue(value js_ast.Expr) bool {
+	switch v := value.Data.(type) {
+	case *js_ast.EFunction, *js_ast.EArrow, *js_ast.EClass,
+		*js_ast.EString, *js_ast.ENumber, *js_ast.EBoolean:
+		return true
+	case *js_ast.EAnnotation:
+		return w.isNonObjectValue(v.Value)
+	case *js_ast.EIdentifier:
+		ref := w.resolveRef(v.Ref)
+		if _, ok := w.varFunc[ref]; ok {
+			return true
+		}
+		_, ok := w.varClass[ref]
+		return ok
+	}
+	return false
+}
 
+// handleModuleExportsValue records the exports of a value that replaced
+// module.exports.
+func (w *walker) handleModuleExportsValue(value js_ast.Expr) {
 	switch v := value.Data.(type) {
+	case *js_ast.EIf:
+		// module.exports = typeof foo !== "undefined" ? foo : require("./fallback")
+		switch w.evaluateCondition(v.Test) {
+		case condTrue:
+			w.handleModuleExportsValue(v.Yes)
+		case condFalse:
+			w.handleModuleExportsValue(v.No)
+		default:
+			// Either branch may be taken, so record both
+			w.conditionalDepth++
+			w.handleModuleExportsValue(v.Yes)
+			w.handleModuleExportsValue(v.No)
+			w.conditionalDepth--
+		}
+
 	case *js_ast.EObject:
+		w.countPattern("moduleExportsObject")
 		w.handleModuleExportsObject(v)
+		if w.opts.SynthesizeDefault {
+			w.defaultIsModuleExports = true
+		}
 
 	case *js_ast.ECall:
 		// module.exports = require("lib")
@@ -552,11 +1948,36 @@ func (w *walker) handleModuleExportsAssignment(value js_ast.Expr) {
 			w.addReexport(path)
 			return
 		}
-		// module.exports = require("lib")()
+		// module.exports = require("lib")() or require("lib")(require("./config"))
 		if path := w.extractRequireCall(v); path != "" {
 			w.addReexport(path + "()")
 			return
 		}
+		// module.exports = Object.assign({}, require("./a"), { b: 1 })
+		if w.isObjectAssign(v) && len(v.Args) >= 1 {
+			if _, ok := v.Args[0].Data.(*js_ast.EObject); ok {
+				w.countPattern("objectAssign")
+				w.handleObjectAssignToModuleExports(v.Args)
+				return
+			}
+			// module.exports = Object.assign(Object.create(null), { a: 1 })
+			if w.isEmptyObjectCreate(v.Args[0]) {
+				w.countPattern("objectAssign")
+				w.handleObjectAssignToModuleExports(v.Args[1:])
+				return
+			}
+		}
+		// module.exports = Object.create(proto, { x: { value: 1 } })
+		if w.isObjectCreate(v) && len(v.Args) == 2 {
+			if descriptors, ok := v.Args[1].Data.(*js_ast.EObject); ok {
+				w.handleObjectCreateDescriptors(descriptors)
+				// Properties inherited from a required prototype are readable too
+				if path, ok := w.extractRequire(v.Args[0]); ok {
+					w.addReexport(path)
+				}
+				return
+			}
+		}
 		// module.exports = fn()
 		if id, ok := v.Target.Data.(*js_ast.EIdentifier); ok {
 			ref := w.resolveRef(id.Ref)
@@ -565,7 +1986,22 @@ func (w *walker) handleModuleExportsAssignment(value js_ast.Expr) {
 				return
 			}
 		}
+		// module.exports = Buffer.from("x")
+		if w.isPrimitiveValue(value) {
+			w.defaultIsModuleExports = true
+			return
+		}
+		// module.exports = (() => { ...; return __webpack_exports__; })() from
+		// webpack's commonjs2 output is known once the runtime calls are seen
+		if ref, ok := w.webpackExportsReturn(v); ok {
+			w.walkCallExpr(v)
+			if _, ok := w.varExports[ref]; !ok {
+				w.markUnknownValue(value)
+			}
+			return
+		}
 		// module.exports = someFunc()
+		w.markUnknownValue(value)
 		w.walkCallExpr(v)
 
 	case *js_ast.EIdentifier:
@@ -576,9 +2012,7 @@ func (w *walker) handleModuleExportsAssignment(value js_ast.Expr) {
 			w.addReexport(path)
 			// Also check if this variable had property assignments
 			if info, ok := w.varObject[ref]; ok {
-				for name := range info.props {
-					w.addExport(name)
-				}
+				info.visit(w.addExport, nil)
 			}
 			// Check for direct property assignments on the require variable
 			w.collectExportsFromVarProps(ref)
@@ -586,16 +2020,12 @@ func (w *walker) handleModuleExportsAssignment(value js_ast.Expr) {
 		}
 		// module.exports = obj variable
 		if info, ok := w.varObject[ref]; ok {
-			for name := range info.props {
-				w.addExport(name)
-			}
-			for _, path := range info.spreads {
-				w.addReexport(path)
-			}
+			info.visit(w.addExport, w.addReexport)
 			return
 		}
 		// module.exports = funcVar (in call mode, analyze func body)
 		if fi, ok := w.varFunc[ref]; ok {
+			w.defaultIsModuleExports = true
 			if w.opts.CallMode {
 				w.analyzeFuncBody(fi.body)
 			} else {
@@ -604,18 +2034,139 @@ func (w *walker) handleModuleExportsAssignment(value js_ast.Expr) {
 			}
 			return
 		}
+		// module.exports = SomeClass is only a default export
+		if _, ok := w.varClass[ref]; ok {
+			w.defaultIsModuleExports = true
+			return
+		}
+		w.markUnknownValue(value)
 
 	case *js_ast.EFunction:
 		// module.exports = function() { ... }
+		w.defaultIsModuleExports = true
 		if w.opts.CallMode {
 			w.analyzeFuncBody(v.Fn.Body.Block.Stmts)
 		}
 
 	case *js_ast.EArrow:
 		// module.exports = () => { ... }
+		w.defaultIsModuleExports = true
 		if w.opts.CallMode {
 			w.analyzeFuncBody(v.Body.Block.Stmts)
 		}
+
+	case *js_ast.EDot, *js_ast.EIndex:
+		// module.exports = require("./a").b.c
+		if path, ok := w.extractRequireBase(value); ok {
+			w.addReexport(path)
+			return
+		}
+		w.markUnknownValue(value)
+
+	case *js_ast.ENew:
+		// module.exports = new SomeClass()
+		w.defaultIsModuleExports = true
+		if w.opts.InstanceExports {
+			if id, ok := v.Target.Data.(*js_ast.EIdentifier); ok {
+				if class, ok := w.varClass[w.resolveRef(id.Ref)]; ok {
+					w.collectInstanceProps(class)
+				}
+			}
+		}
+
+	case *js_ast.EString, *js_ast.ENumber, *js_ast.EBigInt, *js_ast.EBoolean, *js_ast.ENull:
+		// module.exports = "value" is only a default export
+		w.defaultIsModuleExports = true
+
+	case *js_ast.EAnnotation:
+		// The parser wraps class expressions in a purity annotation
+		w.handleModuleExportsValue(v.Value)
+
+	case *js_ast.EClass:
+		// module.exports = class { ... } has no named exports to extract
+		w.defaultIsModuleExports = true
+
+	case *js_ast.EUndefined:
+
+	default:
+		if w.isPrimitiveValue(value) {
+			w.defaultIsModuleExports = true
+			return
+		}
+		w.markUnknownValue(value)
+	}
+}
+
+// isPrimitiveValue reports whether value is a primitive or buffer built from
+// literals, e.g. -1, `text` or Buffer.from("x"), which has no named exports.
+func (w *walker) isPrimitiveValue(value js_ast.Expr) bool {
+	switch v := value.Data.(type) {
+	case *js_ast.EString, *js_ast.ENumber, *js_ast.EBigInt, *js_ast.EBoolean, *js_ast.ENull:
+		return true
+	case *js_ast.ETemplate:
+		return v.TagOrNil.Data == nil
+	case *js_ast.EUnary:
+		return (v.Op == js_ast.UnOpNeg || v.Op == js_ast.UnOpPos || v.Op == js_ast.UnOpNot) && w.isPrimitiveValue(v.Value)
+	case *js_ast.ECall:
+		// Buffer.from("x"), Buffer.alloc(16)
+		dot, ok := v.Target.Data.(*js_ast.EDot)
+		if !ok || (dot.Name != "from" && dot.Name != "alloc") {
+			return false
+		}
+		id, ok := dot.Target.Data.(*js_ast.EIdentifier)
+		return ok && w.symbolName(id.Ref) == "Buffer"
+	}
+	return false
+}
+
+// markUnknownValue notes a module.exports value that no names could be
+// extracted from, and records a warning if Options.ReportUnknownPatterns is set.
+func (w *walker) markUnknownValue(value js_ast.Expr) {
+	w.hasUnknownExports = true
+	if !w.opts.ReportUnknownPatterns {
+		return
+	}
+	kind := strings.TrimPrefix(fmt.Sprintf("%T", value.Data), "*js_ast.")
+	w.warnings = append(w.warnings, Warning{
+		Text: fmt.Sprintf("unrecognized module.exports value (%s)", kind),
+		Loc:  value.Loc,
+	})
+}
+
+// collectInstanceProps adds the own properties an instance of a class gets
+// from its field declarations and this.x assignments in its constructor.
+func (w *walker) collectInstanceProps(class *js_ast.Class) {
+	for _, prop := range class.Properties {
+		if prop.Flags.Has(js_ast.PropertyIsStatic) {
+			continue
+		}
+		switch prop.Kind {
+		case js_ast.PropertyField:
+			if name := w.exprToString(prop.Key); name != "" {
+				w.addExport(name)
+			}
+		case js_ast.PropertyMethod:
+			if w.exprToString(prop.Key) != "constructor" {
+				continue
+			}
+			fn, ok := prop.ValueOrNil.Data.(*js_ast.EFunction)
+			if !ok {
+				continue
+			}
+			for _, stmt := range fn.Fn.Body.Block.Stmts {
+				expr, ok := stmt.Data.(*js_ast.SExpr)
+				if !ok {
+					continue
+				}
+				if bin, ok := expr.Value.Data.(*js_ast.EBinary); ok && bin.Op == js_ast.BinOpAssign {
+					if dot, ok := bin.Left.Data.(*js_ast.EDot); ok {
+						if _, ok := dot.Target.Data.(*js_ast.EThis); ok {
+							w.addExport(dot.Name)
+						}
+					}
+				}
+			}
+		}
 	}
 }
 
@@ -645,10 +2196,37 @@ func (w *walker) handleModuleExportsObject(obj *js_ast.EObject) {
 			continue
 		}
 		name := w.exprToString(prop.Key)
-		if name != "" {
-			w.addExport(name)
+		if name == "" {
+			continue
+		}
+		// get Foo() { return require("./Foo") } lazily forwards a module
+		if value, ok := getterReturnValue(prop); ok {
+			w.addExportFrom(name, value)
+			continue
 		}
+		if prop.Kind == js_ast.PropertyField && prop.ValueOrNil.Data != nil {
+			w.addExportFrom(name, prop.ValueOrNil)
+			continue
+		}
+		w.addExport(name)
+	}
+}
+
+// getterReturnValue returns the returned expression of a getter property whose
+// body is a single return statement.
+func getterReturnValue(prop js_ast.Property) (js_ast.Expr, bool) {
+	if prop.Kind != js_ast.PropertyGetter {
+		return js_ast.Expr{}, false
+	}
+	fn, ok := prop.ValueOrNil.Data.(*js_ast.EFunction)
+	if !ok || len(fn.Fn.Body.Block.Stmts) != 1 {
+		return js_ast.Expr{}, false
+	}
+	ret, ok := fn.Fn.Body.Block.Stmts[0].Data.(*js_ast.SReturn)
+	if !ok || ret.ValueOrNil.Data == nil {
+		return js_ast.Expr{}, false
 	}
+	return ret.ValueOrNil, true
 }
 
 // handleSpreadProp handles spread properties in object literals.
@@ -658,7 +2236,8 @@ func (w *walker) handleSpreadProp(prop js_ast.Property) {
 		// Try Key for spread-only properties
 		spread = prop.Key
 	}
-	if path, ok := w.extractRequire(spread); ok {
+	// ...require("mod") or ...require("mod").default
+	if path, ok := w.extractRequireBase(spread); ok {
 		w.addReexport(path)
 		return
 	}
@@ -666,11 +2245,14 @@ func (w *walker) handleSpreadProp(prop js_ast.Property) {
 	if id, ok := spread.Data.(*js_ast.EIdentifier); ok {
 		ref := w.resolveRef(id.Ref)
 		if info, ok := w.varObject[ref]; ok {
-			for name := range info.props {
-				w.addExport(name)
-			}
-			for _, path := range info.spreads {
-				w.addReexport(path)
+			info.visit(w.addExport, w.addReexport)
+		}
+	}
+	// ...makeExports() -> keys of the object the local function returns
+	if call, ok := spread.Data.(*js_ast.ECall); ok && (w.opts.CallMode || w.opts.ObjectSpreadFromFunctionResult) {
+		if id, ok := call.Target.Data.(*js_ast.EIdentifier); ok {
+			if fi, ok := w.varFunc[w.resolveRef(id.Ref)]; ok {
+				w.analyzeFuncBody(fi.body)
 			}
 		}
 	}
@@ -688,12 +2270,11 @@ func (w *walker) handleDefineProperty(call *js_ast.ECall) {
 	// Allow (0, exports) as target
 	target = w.unwrapCommaExpr(target)
 
-	isExports := w.isExportsRef(target) || w.isModuleExportsAccess(target)
-	if !isExports {
+	if !w.isExportsTarget(target) {
 		return
 	}
 
-	name := w.exprToString(nameExpr)
+	name := w.indexKey(nameExpr)
 	if name == "" {
 		return
 	}
@@ -706,6 +2287,11 @@ func (w *walker) handleDefineProperty(call *js_ast.ECall) {
 				key := w.exprToString(prop.Key)
 				if key == "value" || key == "get" {
 					hasValueOrGet = true
+					// { get: function () { return y.x; } } forwards y.x
+					if value, ok := descriptorValue(key, prop); ok {
+						w.addExportFrom(name, value)
+						return
+					}
 					break
 				}
 			}
@@ -718,6 +2304,31 @@ func (w *walker) handleDefineProperty(call *js_ast.ECall) {
 	w.addExport(name)
 }
 
+// descriptorValue returns the value a property descriptor's value or get
+// property provides, for getters whose body is a single return statement.
+func descriptorValue(key string, prop js_ast.Property) (js_ast.Expr, bool) {
+	if key == "value" {
+		return prop.ValueOrNil, prop.ValueOrNil.Data != nil
+	}
+	switch fn := prop.ValueOrNil.Data.(type) {
+	case *js_ast.EFunction:
+		// get: function () { return y.x; } or get() { return y.x; }
+		if len(fn.Fn.Body.Block.Stmts) == 1 {
+			if ret, ok := fn.Fn.Body.Block.Stmts[0].Data.(*js_ast.SReturn); ok && ret.ValueOrNil.Data != nil {
+				return ret.ValueOrNil, true
+			}
+		}
+	case *js_ast.EArrow:
+		// get: () => y.x
+		if len(fn.Body.Block.Stmts) == 1 {
+			if ret, ok := fn.Body.Block.Stmts[0].Data.(*js_ast.SReturn); ok && ret.ValueOrNil.Data != nil {
+				return ret.ValueOrNil, true
+			}
+		}
+	}
+	return js_ast.Expr{}, false
+}
+
 // handleModuleDefineProperty handles Object.defineProperty(module, "exports", { value: {...} }).
 func (w *walker) handleModuleDefineProperty(call *js_ast.ECall) {
 	if len(call.Args) < 3 {
@@ -738,9 +2349,7 @@ func (w *walker) handleModuleDefineProperty(call *js_ast.ECall) {
 			if key == "value" {
 				if innerObj, ok := prop.ValueOrNil.Data.(*js_ast.EObject); ok {
 					// Reset exports since this replaces module.exports
-					w.moduleExportsOverridden = true
-					w.exports = make(map[string]struct{})
-					w.reexports = make(map[string]struct{})
+					w.overrideModuleExports()
 					w.handleModuleExportsObject(innerObj)
 				}
 				return
@@ -760,12 +2369,21 @@ func (w *walker) handleObjectAssignToModuleExports(args []js_ast.Expr) {
 					continue
 				}
 				name := w.exprToString(prop.Key)
-				if name != "" {
+				if name == "" {
+					continue
+				}
+				// Later arguments shadow earlier ones, so the last
+				// definition decides the export's source.
+				if prop.ValueOrNil.Data != nil {
+					w.addExportFrom(name, prop.ValueOrNil)
+				} else {
 					w.addExport(name)
 				}
 			}
-		case *js_ast.ECall:
-			if path, ok := w.extractRequire(arg); ok {
+		case *js_ast.ECall, *js_ast.EDot, *js_ast.EIndex:
+			// require("mod") or require("mod").default, whose keys can't be
+			// known statically
+			if path, ok := w.extractRequireBase(arg); ok {
 				w.addReexport(path)
 			}
 		case *js_ast.EIdentifier:
@@ -773,6 +2391,11 @@ func (w *walker) handleObjectAssignToModuleExports(args []js_ast.Expr) {
 			if path, ok := w.varRequire[ref]; ok {
 				w.addReexport(path)
 			}
+		case *js_ast.ESpread:
+			// ...[require("a"), require("b")]
+			if arr, ok := v.Value.Data.(*js_ast.EArray); ok {
+				w.handleObjectAssignToModuleExports(arr.Items)
+			}
 		}
 	}
 }
@@ -785,9 +2408,7 @@ func (w *walker) handleObjectAssignToModule(args []js_ast.Expr) {
 				name := w.exprToString(prop.Key)
 				if name == "exports" {
 					// module.exports is being replaced
-					w.moduleExportsOverridden = true
-					w.exports = make(map[string]struct{})
-					w.reexports = make(map[string]struct{})
+					w.overrideModuleExports()
 					if innerObj, ok := prop.ValueOrNil.Data.(*js_ast.EObject); ok {
 						w.handleModuleExportsObject(innerObj)
 					}
@@ -809,11 +2430,8 @@ func (w *walker) isExportStarCall(call *js_ast.ECall) bool {
 	}
 
 	// Direct: __exportStar(...)
-	if id, ok := call.Target.Data.(*js_ast.EIdentifier); ok {
-		name := w.symbolName(id.Ref)
-		if name == "__exportStar" {
-			return true
-		}
+	if id, ok := call.Target.Data.(*js_ast.EIdentifier); ok && w.isExportStarIdentifier(id) {
+		return true
 	}
 
 	// require("tslib").__exportStar(...)
@@ -826,17 +2444,24 @@ func (w *walker) isExportStarCall(call *js_ast.ECall) bool {
 		if dot, ok := target.Data.(*js_ast.EDot); ok && dot.Name == "__exportStar" {
 			return true
 		}
-		if id, ok := target.Data.(*js_ast.EIdentifier); ok {
-			name := w.symbolName(id.Ref)
-			if name == "__exportStar" {
-				return true
-			}
+		if id, ok := target.Data.(*js_ast.EIdentifier); ok && w.isExportStarIdentifier(id) {
+			return true
 		}
 	}
 
 	return false
 }
 
+// isExportStarIdentifier checks for __exportStar or a renamed binding of it
+// destructured from require().
+func (w *walker) isExportStarIdentifier(id *js_ast.EIdentifier) bool {
+	if w.symbolName(id.Ref) == "__exportStar" {
+		return true
+	}
+	_, ok := w.exportStarAliases[w.resolveRef(id.Ref)]
+	return ok
+}
+
 // handleExportStarCall processes __exportStar({...}, exports) or __exportStar(require("..."), exports).
 func (w *walker) handleExportStarCall(call *js_ast.ECall) {
 	if len(call.Args) < 2 {
@@ -847,8 +2472,23 @@ func (w *walker) handleExportStarCall(call *js_ast.ECall) {
 	if obj, ok := first.Data.(*js_ast.EObject); ok {
 		for _, prop := range obj.Properties {
 			name := w.exprToString(prop.Key)
-			if name != "" {
+			if name == "" {
+				continue
+			}
+			// { get a() { return require("./a").a } }
+			value, ok := getterReturnValue(prop)
+			if !ok && prop.Kind == js_ast.PropertyField {
+				value = prop.ValueOrNil
+			}
+			if value.Data == nil {
 				w.addExport(name)
+				continue
+			}
+			w.addExportFrom(name, value)
+			if w.opts.IncludeReexportFromExportStarObject {
+				if path, ok := w.extractRequireBase(value); ok {
+					w.addReexport(path)
+				}
 			}
 		}
 		return
@@ -859,6 +2499,117 @@ func (w *walker) handleExportStarCall(call *js_ast.ECall) {
 	}
 }
 
+// isCopyPropsCall checks for a call to one of Options.CopyPropsFunctions that
+// copies into exports or module.exports: copyProps(exports, source).
+func (w *walker) isCopyPropsCall(call *js_ast.ECall) bool {
+	if len(w.copyProps) == 0 || len(call.Args) < 2 {
+		return false
+	}
+	id, ok := call.Target.Data.(*js_ast.EIdentifier)
+	if !ok {
+		return false
+	}
+	if _, ok := w.copyProps[w.symbolName(id.Ref)]; !ok {
+		return false
+	}
+	return w.isExportsTarget(call.Args[0]) || w.isExportsAlias(call.Args[0])
+}
+
+// webpackRuntimeMethod returns "d" or "r" for __webpack_require__.d(...) and
+// __webpack_require__.r(...) calls, or "" otherwise.
+func (w *walker) webpackRuntimeMethod(call *js_ast.ECall) string {
+	if len(call.Args) == 0 {
+		return ""
+	}
+	dot, ok := call.Target.Data.(*js_ast.EDot)
+	if !ok || (dot.Name != "d" && dot.Name != "r") {
+		return ""
+	}
+	id, ok := dot.Target.Data.(*js_ast.EIdentifier)
+	if !ok || w.symbolName(id.Ref) != "__webpack_require__" {
+		return ""
+	}
+	if _, ok := call.Args[0].Data.(*js_ast.EIdentifier); !ok {
+		return ""
+	}
+	return dot.Name
+}
+
+// webpackExportsReturn returns the __webpack_exports__ variable returned at
+// the end of an IIFE, as emitted by webpack for libraryTarget commonjs2.
+func (w *walker) webpackExportsReturn(call *js_ast.ECall) (ast.Ref, bool) {
+	if len(call.Args) != 0 {
+		return ast.Ref{}, false
+	}
+	var stmts []js_ast.Stmt
+	switch fn := call.Target.Data.(type) {
+	case *js_ast.EArrow:
+		stmts = fn.Body.Block.Stmts
+	case *js_ast.EFunction:
+		stmts = fn.Fn.Body.Block.Stmts
+	default:
+		return ast.Ref{}, false
+	}
+	if len(stmts) == 0 {
+		return ast.Ref{}, false
+	}
+	ret, ok := stmts[len(stmts)-1].Data.(*js_ast.SReturn)
+	if !ok || ret.ValueOrNil.Data == nil {
+		return ast.Ref{}, false
+	}
+	id, ok := ret.ValueOrNil.Data.(*js_ast.EIdentifier)
+	if !ok || w.symbolName(id.Ref) != "__webpack_exports__" {
+		return ast.Ref{}, false
+	}
+	return w.resolveRef(id.Ref), true
+}
+
+// handleWebpackRuntimeCall treats the object passed to the webpack 5 runtime
+// helpers as the exports object of the bundle.
+func (w *walker) handleWebpackRuntimeCall(method string, call *js_ast.ECall) {
+	id := call.Args[0].Data.(*js_ast.EIdentifier)
+	w.varExports[w.resolveRef(id.Ref)] = struct{}{}
+
+	switch method {
+	case "r":
+		// Defines __esModule on the exports object
+		w.addExport("__esModule")
+	case "d":
+		// Defines a getter for each key of the object
+		if len(call.Args) < 2 {
+			return
+		}
+		if obj, ok := call.Args[1].Data.(*js_ast.EObject); ok {
+			for _, prop := range obj.Properties {
+				if name := w.exprToString(prop.Key); name != "" {
+					w.addExport(name)
+				}
+			}
+		}
+	}
+}
+
+// isBabelExportCall checks for the two-argument getter helper emitted by Babel:
+// _export(exports, { foo: function() { return foo; } }). Depending on the Babel
+// version the helper is named either _export or _exports.
+func (w *walker) isBabelExportCall(call *js_ast.ECall) bool {
+	if len(call.Args) != 2 {
+		return false
+	}
+	id, ok := call.Target.Data.(*js_ast.EIdentifier)
+	if !ok {
+		return false
+	}
+	if name := w.symbolName(id.Ref); name != "_export" && name != "_exports" {
+		return false
+	}
+	if !w.isExportsAlias(call.Args[0]) && !w.isModuleExportsAccess(call.Args[0]) {
+		return false
+	}
+	_, isObj := call.Args[1].Data.(*js_ast.EObject)
+	return isObj
+}
+
 // isExportCall checks for __export({...}) pattern (esbuild/TypeScript output).
 func (w *walker) isExportCall(call *js_ast.ECall) bool {
 	if len(call.Args) != 1 {
@@ -893,7 +2644,9 @@ func (w *walker) handleExportCall(call *js_ast.ECall) {
 
 // walkIfStmt processes if statements with NODE_ENV-aware evaluation.
 func (w *walker) walkIfStmt(s *js_ast.SIf) {
-	if w.opts.NodeEnv != "" {
+	// require.main === module guards and literal tests like if (false) don't
+	// depend on NODE_ENV
+	if w.opts.NodeEnv != "" || w.isRequireMainCheck(s.Test) || isLiteralCondition(s.Test) {
 		result := w.evaluateCondition(s.Test)
 		switch result {
 		case condTrue:
@@ -932,6 +2685,18 @@ const (
 	condFalse
 )
 
+// isLiteralCondition checks for tests that are statically known regardless of
+// options, such as false, 0, "", or !true.
+func isLiteralCondition(expr js_ast.Expr) bool {
+	switch e := expr.Data.(type) {
+	case *js_ast.EBoolean, *js_ast.ENumber, *js_ast.EString:
+		return true
+	case *js_ast.EUnary:
+		return e.Op == js_ast.UnOpNot && isLiteralCondition(e.Value)
+	}
+	return false
+}
+
 // evaluateCondition evaluates a condition expression, handling NODE_ENV checks.
 func (w *walker) evaluateCondition(expr js_ast.Expr) condResult {
 	switch e := expr.Data.(type) {
@@ -952,6 +2717,25 @@ func (w *walker) evaluateCondition(expr js_ast.Expr) condResult {
 			return condTrue
 		}
 		return condFalse
+	case *js_ast.EDot:
+		// module.parent is set when the module is required, not run directly
+		if w.isModuleParent(expr) {
+			if w.opts.RequireMainIsModule {
+				return condFalse
+			}
+			return condTrue
+		}
+	case *js_ast.ENumber:
+		// if (0) / if (1)
+		if e.Value == 0 || math.IsNaN(e.Value) {
+			return condFalse
+		}
+		return condTrue
+	case *js_ast.EString:
+		if len(e.Value) == 0 {
+			return condFalse
+		}
+		return condTrue
 	}
 	return condUnknown
 }
@@ -988,11 +2772,6 @@ func (w *walker) evaluateConditionBinary(e *js_ast.EBinary) condResult {
 	return condUnknown
 }
 
-// evaluateNodeEnvCondition evaluates a NODE_ENV comparison (returns true if condition evaluates to true).
-func (w *walker) evaluateNodeEnvCondition(expr js_ast.Expr) bool {
-	return w.evaluateCondition(expr) == condTrue
-}
-
 // evaluateEqualityCheck evaluates an equality or inequality check.
 func (w *walker) evaluateEqualityCheck(left, right js_ast.Expr, isEquals bool) condResult {
 	// Try both orderings
@@ -1015,6 +2794,13 @@ func (w *walker) evaluateEqualityOnce(left, right js_ast.Expr, isEquals bool) co
 		}
 	}
 	if nodeEnvValue == "" {
+		// require.main === module -> false unless analyzing an entry point
+		if w.isRequireMain(left) && w.isModuleRef(right) {
+			if isEquals == w.opts.RequireMainIsModule {
+				return condTrue
+			}
+			return condFalse
+		}
 		// typeof module !== "undefined" -> always true in CJS
 		if w.isTypeofCheck(left, right, "module", "undefined") {
 			// typeof module !== "undefined" => true, typeof module === "undefined" => false
@@ -1051,6 +2837,43 @@ func (w *walker) evaluateEqualityOnce(left, right js_ast.Expr, isEquals bool) co
 	return condTrue
 }
 
+// isRequireMainCheck checks for require.main === module, require.main !== module,
+// or a negation of either.
+func (w *walker) isRequireMainCheck(expr js_ast.Expr) bool {
+	switch e := expr.Data.(type) {
+	case *js_ast.EDot:
+		// module.parent is unset only for the entry point
+		return w.isModuleParent(expr)
+	case *js_ast.EUnary:
+		return e.Op == js_ast.UnOpNot && w.isRequireMainCheck(e.Value)
+	case *js_ast.EBinary:
+		switch e.Op {
+		case js_ast.BinOpLooseEq, js_ast.BinOpStrictEq, js_ast.BinOpLooseNe, js_ast.BinOpStrictNe:
+			return (w.isRequireMain(e.Left) && w.isModuleRef(e.Right)) ||
+				(w.isModuleRef(e.Left) && w.isRequireMain(e.Right))
+		}
+	}
+	return false
+}
+
+// isModuleParent checks for module.parent.
+func (w *walker) isModuleParent(expr js_ast.Expr) bool {
+	dot, ok := expr.Data.(*js_ast.EDot)
+	return ok && dot.Name == "parent" && w.isModuleRef(dot.Target)
+}
+
+// isRequireMain checks if an expression is require.main.
+func (w *walker) isRequireMain(expr js_ast.Expr) bool {
+	dot, ok := expr.Data.(*js_ast.EDot)
+	if !ok || dot.Name != "main" {
+		return false
+	}
+	if id, ok := dot.Target.Data.(*js_ast.EIdentifier); ok {
+		return w.symbolName(id.Ref) == "require"
+	}
+	return false
+}
+
 // isTypeofCheck checks for typeof X <op> "string" pattern.
 func (w *walker) isTypeofCheck(left, right js_ast.Expr, identName, strValue string) bool {
 	unary, ok := left.Data.(*js_ast.EUnary)
@@ -1096,7 +2919,7 @@ func (w *walker) analyzeFuncStmt(stmt js_ast.Stmt) {
 				if id, ok := dot.Target.Data.(*js_ast.EIdentifier); ok {
 					ref := w.resolveRef(id.Ref)
 					if info, ok := w.varObject[ref]; ok {
-						info.props[dot.Name] = struct{}{}
+						info.addProp(dot.Name)
 					}
 				}
 			}
@@ -1151,9 +2974,7 @@ func (w *walker) analyzeReturnValue(expr js_ast.Expr) {
 	case *js_ast.EIdentifier:
 		ref := w.resolveRef(v.Ref)
 		if info, ok := w.varObject[ref]; ok {
-			for name := range info.props {
-				w.addExport(name)
-			}
+			info.visit(w.addExport, nil)
 		}
 	}
 }
@@ -1163,20 +2984,60 @@ func (w *walker) analyzeReturnValue(expr js_ast.Expr) {
 // isExportsRef checks if an expression is a reference to the `exports` symbol.
 func (w *walker) isExportsRef(expr js_ast.Expr) bool {
 	if id, ok := expr.Data.(*js_ast.EIdentifier); ok {
-		return w.symbolName(id.Ref) == "exports"
+		return w.symbolName(id.Ref) == w.exportsName
 	}
-	return false
+	return w.isThisContextMember(expr, "exports")
 }
 
 // isModuleRef checks if an expression is a reference to the `module` symbol.
 func (w *walker) isModuleRef(expr js_ast.Expr) bool {
 	if id, ok := expr.Data.(*js_ast.EIdentifier); ok {
-		return w.symbolName(id.Ref) == "module"
+		if w.symbolName(id.Ref) == w.moduleName {
+			return true
+		}
+		_, isAlias := w.varModule[w.resolveRef(id.Ref)]
+		return isAlias
 	}
-	return false
+	return w.isThisContextMember(expr, "module")
+}
+
+// mapWrapperParams handles wrappers like (function(e, t) { ... })(module, exports)
+// by treating each parameter that receives module or exports as an alias of it.
+func (w *walker) mapWrapperParams(params []js_ast.Arg, args []js_ast.Expr) {
+	for i, arg := range args {
+		if i >= len(params) {
+			return
+		}
+		param, ok := params[i].Binding.Data.(*js_ast.BIdentifier)
+		if !ok {
+			continue
+		}
+		if w.isExportsAlias(arg) {
+			w.varExports[w.resolveRef(param.Ref)] = struct{}{}
+		} else if w.isModuleRef(arg) {
+			w.varModule[w.resolveRef(param.Ref)] = struct{}{}
+		} else if w.isModuleExportsAccess(arg) {
+			w.varModExports[w.resolveRef(param.Ref)] = struct{}{}
+		}
+	}
+}
+
+// isThisContextMember checks for this.<name> inside a factory invoked with
+// .call(this) or .apply(this).
+func (w *walker) isThisContextMember(expr js_ast.Expr, name string) bool {
+	if w.thisContextDepth == 0 {
+		return false
+	}
+	dot, ok := expr.Data.(*js_ast.EDot)
+	if !ok || dot.Name != name {
+		return false
+	}
+	_, ok = dot.Target.Data.(*js_ast.EThis)
+	return ok
 }
 
 // isModuleExportsAccess checks for module.exports or module["exports"].
+// Optional chains (module?.exports, module?.["exports"]) are treated the same.
 func (w *walker) isModuleExportsAccess(expr js_ast.Expr) bool {
 	if dot, ok := expr.Data.(*js_ast.EDot); ok {
 		if dot.Name == "exports" {
@@ -1184,13 +3045,30 @@ func (w *walker) isModuleExportsAccess(expr js_ast.Expr) bool {
 		}
 	}
 	if idx, ok := expr.Data.(*js_ast.EIndex); ok {
-		if w.exprToString(idx.Index) == "exports" {
+		if w.indexKey(idx.Index) == "exports" {
 			return w.isModuleRef(idx.Target)
 		}
 	}
 	return false
 }
 
+// isExportsAlias checks for `exports` or a variable known to alias it.
+func (w *walker) isExportsAlias(expr js_ast.Expr) bool {
+	if w.isExportsRef(expr) {
+		return true
+	}
+	if id, ok := expr.Data.(*js_ast.EIdentifier); ok {
+		_, isAlias := w.varExports[w.resolveRef(id.Ref)]
+		return isAlias
+	}
+	return false
+}
+
+// isExportsTarget checks for either `exports` or module.exports.
+func (w *walker) isExportsTarget(expr js_ast.Expr) bool {
+	return w.isExportsRef(expr) || w.isModuleExportsAccess(expr)
+}
+
 // getExportsPropertyName returns the property name if expr is exports.X or exports["X"].
 func (w *walker) getExportsPropertyName(expr js_ast.Expr) (string, bool) {
 	if dot, ok := expr.Data.(*js_ast.EDot); ok {
@@ -1200,7 +3078,7 @@ func (w *walker) getExportsPropertyName(expr js_ast.Expr) (string, bool) {
 	}
 	if idx, ok := expr.Data.(*js_ast.EIndex); ok {
 		if w.isExportsRef(idx.Target) {
-			name := w.exprToString(idx.Index)
+			name := w.indexKey(idx.Index)
 			if name != "" {
 				return name, true
 			}
@@ -1218,7 +3096,7 @@ func (w *walker) getModuleExportsPropertyName(expr js_ast.Expr) (string, bool) {
 	}
 	if idx, ok := expr.Data.(*js_ast.EIndex); ok {
 		if w.isModuleExportsAccess(idx.Target) {
-			name := w.exprToString(idx.Index)
+			name := w.indexKey(idx.Index)
 			if name != "" {
 				return name, true
 			}
@@ -1227,28 +3105,145 @@ func (w *walker) getModuleExportsPropertyName(expr js_ast.Expr) (string, bool) {
 	return "", false
 }
 
+// extractRequireResolve checks for require.resolve("mod") and returns the path.
+func (w *walker) extractRequireResolve(call *js_ast.ECall) (string, bool) {
+	if len(call.Args) < 1 {
+		return "", false
+	}
+	dot, ok := call.Target.Data.(*js_ast.EDot)
+	if !ok || dot.Name != "resolve" {
+		return "", false
+	}
+	id, ok := dot.Target.Data.(*js_ast.EIdentifier)
+	if !ok || w.symbolName(id.Ref) != "require" {
+		return "", false
+	}
+	path := w.exprToString(call.Args[0])
+	return path, path != ""
+}
+
+// requirePath returns the static value of a require() argument. String
+// concatenations and template literals are folded when every dynamic part is
+// an expression like process.platform with a value in Options.Define, and
+// such an expression on its own is replaced by that value.
+func (w *walker) requirePath(expr js_ast.Expr) (string, bool) {
+	switch expr.Data.(type) {
+	case *js_ast.EBinary, *js_ast.ETemplate, *js_ast.EDot, *js_ast.EIdentifier, *js_ast.EString:
+		return w.foldPath(expr)
+	}
+	path := w.exprToString(expr)
+	return path, path != ""
+}
+
+// foldPath returns the value of a require() path built from string literals
+// and expressions defined in Options.Define, or false if any part is unknown.
+func (w *walker) foldPath(expr js_ast.Expr) (string, bool) {
+	switch e := expr.Data.(type) {
+	case *js_ast.EBinary:
+		if e.Op != js_ast.BinOpAdd {
+			return "", false
+		}
+		left, ok := w.foldPath(e.Left)
+		if !ok {
+			return "", false
+		}
+		right, ok := w.foldPath(e.Right)
+		if !ok {
+			return "", false
+		}
+		return left + right, true
+	case *js_ast.ETemplate:
+		if e.TagOrNil.Data != nil {
+			return "", false
+		}
+		var sb strings.Builder
+		sb.WriteString(helpers.UTF16ToString(e.HeadCooked))
+		for _, part := range e.Parts {
+			value, ok := w.foldPath(part.Value)
+			if !ok {
+				return "", false
+			}
+			sb.WriteString(value)
+			sb.WriteString(helpers.UTF16ToString(part.TailCooked))
+		}
+		return sb.String(), true
+	case *js_ast.EDot, *js_ast.EIdentifier:
+		if name := w.dottedName(expr); name != "" {
+			value, ok := w.opts.Define[name]
+			return value, ok
+		}
+	case *js_ast.EString:
+		return helpers.UTF16ToString(e.Value), true
+	}
+	return "", false
+}
+
+// dottedName returns the source text of a member chain such as
+// process.platform, or "" if expr isn't one.
+func (w *walker) dottedName(expr js_ast.Expr) string {
+	switch e := expr.Data.(type) {
+	case *js_ast.EIdentifier:
+		return w.symbolName(e.Ref)
+	case *js_ast.EDot:
+		if target := w.dottedName(e.Target); target != "" {
+			return target + "." + e.Name
+		}
+	}
+	return ""
+}
+
 // extractRequire extracts the module path from a require("...") call expression.
+// Every path it recognizes is also recorded for Result.Requires.
 func (w *walker) extractRequire(expr js_ast.Expr) (string, bool) {
 	call, ok := expr.Data.(*js_ast.ECall)
 	if !ok {
 		return "", false
 	}
-	if len(call.Args) != 1 {
+	if len(call.Args) == 0 {
 		return "", false
 	}
-	if id, ok := call.Target.Data.(*js_ast.EIdentifier); ok {
-		name := w.symbolName(id.Ref)
-		if name == "require" {
-			path := w.exprToString(call.Args[0])
-			if path != "" {
-				return path, true
-			}
+	id, ok := call.Target.Data.(*js_ast.EIdentifier)
+	if !ok {
+		return "", false
+	}
+	name := w.symbolName(id.Ref)
+	if name == "require" {
+		if len(call.Args) != 1 {
+			return "", false
+		}
+	} else if _, ok := w.requireLike[name]; ok {
+		// __importStar(require("mod")) wraps the real require
+		if path, ok := w.extractRequire(call.Args[0]); ok {
+			return path, true
 		}
+	} else {
+		return "", false
+	}
+	if path, ok := w.requirePath(call.Args[0]); ok && path != "" {
+		w.addRequire(path)
+		return path, true
 	}
 	return "", false
 }
 
-// extractRequireCall extracts the module path from require("...")() (function call on require result).
+// extractRequireBase extracts the module path from require("...") or a member
+// access chain on its result, such as require("...").default.
+func (w *walker) extractRequireBase(expr js_ast.Expr) (string, bool) {
+	for {
+		switch e := expr.Data.(type) {
+		case *js_ast.EDot:
+			expr = e.Target
+			continue
+		case *js_ast.EIndex:
+			expr = e.Target
+			continue
+		}
+		return w.extractRequire(expr)
+	}
+}
+
+// extractRequireCall extracts the module path from require("...")(...), a call
+// on the required module with any arguments.
 func (w *walker) extractRequireCall(call *js_ast.ECall) string {
 	if innerCall, ok := call.Target.Data.(*js_ast.ECall); ok {
 		if path, ok := w.extractRequire(js_ast.Expr{Data: innerCall}); ok {
@@ -1277,7 +3272,7 @@ func (w *walker) isObjectDefineProperty(call *js_ast.ECall) bool {
 	}
 
 	target := w.unwrapCommaExpr(call.Args[0])
-	return w.isExportsRef(target) || w.isModuleExportsAccess(target)
+	return w.isExportsTarget(target)
 }
 
 // isModuleDefineProperty checks for Object.defineProperty(module, "exports", ...).
@@ -1301,19 +3296,110 @@ func (w *walker) isModuleDefineProperty(call *js_ast.ECall) bool {
 	return w.isModuleRef(call.Args[0])
 }
 
-// isObjectAssign checks for Object.assign(...).
-func (w *walker) isObjectAssign(call *js_ast.ECall) bool {
+// isObjectCreate checks for Object.create(...).
+func (w *walker) isObjectCreate(call *js_ast.ECall) bool {
 	dot, ok := call.Target.Data.(*js_ast.EDot)
-	if !ok || dot.Name != "assign" {
+	if !ok || dot.Name != "create" {
 		return false
 	}
 	if id, ok := dot.Target.Data.(*js_ast.EIdentifier); ok {
-		name := w.symbolName(id.Ref)
-		return name == "Object"
+		return w.symbolName(id.Ref) == "Object"
+	}
+	return false
+}
+
+// isObjectFreeze reports whether call is Object.freeze(obj), Object.seal(obj)
+// or Object.preventExtensions(obj), which return obj unchanged.
+func (w *walker) isObjectFreeze(call *js_ast.ECall) bool {
+	dot, ok := call.Target.Data.(*js_ast.EDot)
+	if !ok || len(call.Args) != 1 {
+		return false
+	}
+	switch dot.Name {
+	case "freeze", "seal", "preventExtensions":
+	default:
+		return false
+	}
+	if id, ok := dot.Target.Data.(*js_ast.EIdentifier); ok {
+		return w.symbolName(id.Ref) == "Object"
 	}
 	return false
 }
 
+// isEmptyObjectCreate reports whether expr is Object.create(null) or
+// Object.create({}), which create an object with no own or inherited keys.
+func (w *walker) isEmptyObjectCreate(expr js_ast.Expr) bool {
+	call, ok := expr.Data.(*js_ast.ECall)
+	if !ok || !w.isObjectCreate(call) || len(call.Args) != 1 {
+		return false
+	}
+	switch proto := call.Args[0].Data.(type) {
+	case *js_ast.ENull:
+		return true
+	case *js_ast.EObject:
+		return len(proto.Properties) == 0
+	}
+	return false
+}
+
+// handleObjectCreateDescriptors adds each key of an Object.create descriptors
+// object whose descriptor defines a value or a getter.
+func (w *walker) handleObjectCreateDescriptors(descriptors *js_ast.EObject) {
+	for _, prop := range descriptors.Properties {
+		name := w.exprToString(prop.Key)
+		if name == "" {
+			continue
+		}
+		desc, ok := prop.ValueOrNil.Data.(*js_ast.EObject)
+		if !ok {
+			continue
+		}
+		for _, field := range desc.Properties {
+			if key := w.exprToString(field.Key); key == "value" || key == "get" {
+				w.addExport(name)
+				break
+			}
+		}
+	}
+}
+
+// isObjectAssign checks for Object.assign(...) or tslib's __assign helper.
+func (w *walker) isObjectAssign(call *js_ast.ECall) bool {
+	// (0, tslib_1.__assign)(...) or (0, __assign)(...)
+	target := w.unwrapCommaExpr(call.Target)
+	switch t := target.Data.(type) {
+	case *js_ast.EDot:
+		// tslib.__assign(...) is TypeScript's Object.assign polyfill
+		if t.Name == "__assign" {
+			return w.isTslib(t.Target)
+		}
+		if t.Name != "assign" {
+			return false
+		}
+		if id, ok := t.Target.Data.(*js_ast.EIdentifier); ok {
+			return w.symbolName(id.Ref) == "Object"
+		}
+	case *js_ast.EIdentifier:
+		// __assign(...) from an inlined or destructured tslib helper
+		return w.symbolName(t.Ref) == "__assign"
+	}
+	return false
+}
+
+// isTslib checks if an expression refers to the tslib helper module, either
+// through a variable bound to require("tslib") or by its conventional name.
+func (w *walker) isTslib(expr js_ast.Expr) bool {
+	id, ok := expr.Data.(*js_ast.EIdentifier)
+	if !ok {
+		return false
+	}
+	if path, ok := w.varRequire[w.resolveRef(id.Ref)]; ok {
+		return path == "tslib"
+	}
+	name := w.symbolName(id.Ref)
+	return name == "tslib" || name == "tslib_1"
+}
+
 // isProcessEnvNodeEnv checks if an expression is process.env.NODE_ENV.
 func (w *walker) isProcessEnvNodeEnv(expr js_ast.Expr) bool {
 	dot, ok := expr.Data.(*js_ast.EDot)
@@ -1372,6 +3458,28 @@ func (w *walker) exprToString(expr js_ast.Expr) string {
 	return ""
 }
 
+// indexKey returns the property name accessed by a computed member index,
+// e.g. "0" for exports[IDX] after const IDX = 0, or "" if it isn't constant.
+func (w *walker) indexKey(index js_ast.Expr) string {
+	if id, ok := index.Data.(*js_ast.EIdentifier); ok {
+		value, ok := w.varConst[w.resolveRef(id.Ref)]
+		if !ok {
+			return ""
+		}
+		index = value
+	}
+	switch e := index.Data.(type) {
+	case *js_ast.EString:
+		return helpers.UTF16ToString(e.Value)
+	case *js_ast.ENumber:
+		if e.Value == math.Trunc(e.Value) && math.Abs(e.Value) < 1e21 {
+			return strconv.FormatFloat(e.Value, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(e.Value, 'g', -1, 64)
+	}
+	return ""
+}
+
 // resolveRef follows symbol links to get the canonical ref.
 func (w *walker) resolveRef(ref ast.Ref) ast.Ref {
 	for {
@@ -1404,12 +3512,12 @@ func (w *walker) symbolName(ref ast.Ref) string {
 func (w *walker) extractObjectProps(obj *js_ast.EObject, info *objInfo) {
 	for _, prop := range obj.Properties {
 		if prop.Kind == js_ast.PropertySpread {
-			// Handle ...require("mod")
+			// Handle ...require("mod") or ...require("mod").default
 			spread := prop.ValueOrNil
 			if spread.Data == nil {
 				spread = prop.Key
 			}
-			if path, ok := w.extractRequire(spread); ok {
+			if path, ok := w.extractRequireBase(spread); ok {
 				info.spreads = append(info.spreads, path)
 				continue
 			}
@@ -1417,17 +3525,14 @@ func (w *walker) extractObjectProps(obj *js_ast.EObject, info *objInfo) {
 			if id, ok := spread.Data.(*js_ast.EIdentifier); ok {
 				ref := w.resolveRef(id.Ref)
 				if other, ok := w.varObject[ref]; ok {
-					for name := range other.props {
-						info.props[name] = struct{}{}
-					}
-					info.spreads = append(info.spreads, other.spreads...)
+					info.parents = append(info.parents, objParent{info: other, n: len(other.names)})
 				}
 			}
 			continue
 		}
 		name := w.exprToString(prop.Key)
 		if name != "" {
-			info.props[name] = struct{}{}
+			info.addProp(name)
 		}
 	}
 }
@@ -1438,11 +3543,17 @@ var annotationRe = regexp.MustCompile(`(?:^|[;\s])0\s*&&\s*\(\s*module\.exports\
 // scanAnnotationPattern scans the raw source for the 0 && (module.exports = {...}) pattern.
 // esbuild's parser constant-folds this away, so we detect it via text matching.
 func (w *walker) scanAnnotationPattern(source, filename string) {
+	// Running the regex over a large file is expensive, so skip it when the
+	// pattern can't possibly be present.
+	if !strings.Contains(source, "&&") || !strings.Contains(source, "module.exports") {
+		return
+	}
 	matches := annotationRe.FindAllStringSubmatch(source, -1)
 	for _, match := range matches {
 		if len(match) < 2 {
 			continue
 		}
+		w.countPattern("annotation")
 		body := match[1]
 		// Parse the property names from the object literal body
 		// Handle: foo, bar, baz or "foo": val, "bar": val
@@ -1465,14 +3576,362 @@ func (w *walker) scanAnnotationPattern(source, filename string) {
 	}
 }
 
+// tsDeclareRe matches an exported declaration such as "export declare const
+// x" or "export function f(): void;" and captures the declared name.
+var tsDeclareRe = regexp.MustCompile(`(?m)^[ \t]*export[ \t]+(?:declare[ \t]+)?(?:abstract[ \t]+|async[ \t]+)?(?:const|let|var|function|class|enum|namespace|module)[ \t]+([A-Za-z_$][\w$]*)`)
+
+// tsExportDefaultRe matches a default export such as "export default class
+// Foo {}". It captures "interface" for type-only exports, and the name of a
+// default function or class if it has one.
+var tsExportDefaultRe = regexp.MustCompile(`(?m)^[ \t]*export[ \t]+default[ \t]+(?:(interface)\b|(?:abstract[ \t]+|async[ \t]+)?(?:(?:function|class)\b[ \t]*\*?[ \t]*([A-Za-z_$][\w$]*)?)?)`)
+
+// tsExportClauseRe matches an export clause such as "export { A, B as C }".
+// Type-only clauses ("export type { T }") don't match.
+var tsExportClauseRe = regexp.MustCompile(`(?m)^[ \t]*export[ \t]*\{([^}]*)\}`)
+
+// scanTSDeclarations adds the names exported by a TypeScript declaration
+// file. The parser removes ambient declarations, and with them any export
+// clauses that only name declarations, so these are read from the source.
+// "export = X" is already seen by the parser as module.exports = X.
+func (w *walker) scanTSDeclarations(source string) {
+	if !strings.Contains(source, "export") {
+		return
+	}
+	source = blankCommentsAndStrings(source)
+	for _, match := range tsDeclareRe.FindAllStringSubmatch(source, -1) {
+		w.addExport(match[1])
+	}
+	for _, match := range tsExportDefaultRe.FindAllStringSubmatch(source, -1) {
+		if match[1] != "" {
+			continue
+		}
+		w.addExport("default")
+		if match[2] != "" {
+			w.defaultName = match[2]
+		}
+	}
+	for _, match := range tsExportClauseRe.FindAllStringSubmatch(source, -1) {
+		for _, item := range strings.Split(match[1], ",") {
+			fields := strings.Fields(item)
+			if len(fields) == 0 || fields[0] == "type" {
+				continue
+			}
+			// A as B exports B
+			w.addExport(fields[len(fields)-1])
+		}
+	}
+}
+
+// blankCommentsAndStrings replaces the contents of comments and string and
+// template literals with spaces, keeping line breaks, so that declarations
+// can be scanned for line by line without matching text inside them.
+func blankCommentsAndStrings(source string) string {
+	out := []byte(source)
+	for i := 0; i < len(out); i++ {
+		switch c := out[i]; {
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			end := strings.Index(source[i+2:], "*/")
+			if end < 0 {
+				end = len(out)
+			} else {
+				end += i + 4
+			}
+			for ; i < end; i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			i--
+		case c == '"' || c == '\'' || c == '`':
+			// Keep the quotes so the literal is still recognizable
+			for i++; i < len(out) && out[i] != c; i++ {
+				if out[i] == '\\' && i+1 < len(out) {
+					out[i] = ' '
+					i++
+				}
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+		}
+	}
+	return string(out)
+}
+
+// exportDirectiveRe matches an @exports tag and the names that follow it.
+var exportDirectiveRe = regexp.MustCompile(`@exports[ \t]+([^\n@]*)`)
+
+// scanExportDirectives reads @exports directives from the comments at the top
+// of the source, before any code.
+func (w *walker) scanExportDirectives(source string) {
+	rest := source
+	if strings.HasPrefix(rest, "#!") {
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			rest = rest[i:]
+		} else {
+			return
+		}
+	}
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			i := strings.IndexByte(rest, '\n')
+			if i < 0 {
+				return
+			}
+			rest = rest[i:]
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest, "*/")
+			if end < 0 {
+				return
+			}
+			comment := rest[2:end]
+			for _, match := range exportDirectiveRe.FindAllStringSubmatch(comment, -1) {
+				w.countPattern("exportDirective")
+				for _, name := range strings.FieldsFunc(match[1], func(r rune) bool {
+					return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '*' || r == '/'
+				}) {
+					w.addExport(name)
+				}
+			}
+			rest = rest[end+2:]
+		default:
+			return
+		}
+	}
+}
+
 // addExport adds an export name.
 func (w *walker) addExport(name string) {
+	if w.opts.OnlyReexports {
+		return
+	}
 	w.exports[name] = struct{}{}
+	if w.conditionalDepth == 0 {
+		w.definiteExports[name] = struct{}{}
+	}
+}
+
+// addExportFrom adds an export name assigned from value, recording where the
+// value is forwarded from when export sources are tracked.
+func (w *walker) addExportFrom(name string, value js_ast.Expr) {
+	// module.exports.__proto__ = require("./base") inherits the members of
+	// base rather than exporting a property named __proto__
+	if name == "__proto__" {
+		if path, ok := w.extractRequire(value); ok {
+			w.addReexport(path)
+		}
+		return
+	}
+	if w.opts.OnlyReexports {
+		return
+	}
+
+	w.addExport(name)
+
+	// exports.default = void 0 is a hoisted placeholder that transpilers emit
+	// before the real assignment, so it doesn't replace what's known so far.
+	final := value
+	for {
+		bin, ok := final.Data.(*js_ast.EBinary)
+		if !ok || bin.Op != js_ast.BinOpAssign {
+			break
+		}
+		final = bin.Right
+	}
+	if _, ok := final.Data.(*js_ast.EUndefined); ok {
+		return
+	}
+
+	// exports.default = App
+	if name == "default" {
+		if defaultName := w.defaultNameOf(final); defaultName != "" {
+			w.defaultName = defaultName
+		}
+	}
+
+	if !w.opts.TrackExportSources {
+		return
+	}
+	if src, ok := w.exportSourceOf(value); ok {
+		w.sources[name] = src
+	} else {
+		// A later local assignment replaces an earlier forwarded value
+		delete(w.sources, name)
+	}
+}
+
+// exportSourceOf determines which module a value is forwarded from.
+func (w *walker) exportSourceOf(value js_ast.Expr) (ExportSource, bool) {
+	// exports.a = exports.b = <value>
+	for {
+		bin, ok := value.Data.(*js_ast.EBinary)
+		if !ok || bin.Op != js_ast.BinOpAssign {
+			break
+		}
+		value = bin.Right
+	}
+
+	// require("mod") forwards the whole module
+	if path, ok := w.extractRequire(value); ok {
+		return ExportSource{Path: path}, true
+	}
+
+	// const { foo } = require("mod"); exports.foo = foo
+	// const foo = require("mod"); exports.foo = foo
+	if id, ok := value.Data.(*js_ast.EIdentifier); ok {
+		ref := w.resolveRef(id.Ref)
+		if src, ok := w.varRequireMember[ref]; ok {
+			return src, true
+		}
+		if path, ok := w.varRequire[ref]; ok {
+			return ExportSource{Path: path}, true
+		}
+	}
+
+	// require("mod").foo, require("mod")["foo"] or mod.foo
+	switch v := value.Data.(type) {
+	case *js_ast.EDot:
+		if path, ok := w.requiredModule(v.Target); ok {
+			return ExportSource{Path: path, Member: v.Name}, true
+		}
+	case *js_ast.EIndex:
+		if member := w.indexKey(v.Index); member != "" {
+			if path, ok := w.requiredModule(v.Target); ok {
+				return ExportSource{Path: path, Member: member}, true
+			}
+		}
+	}
+	return ExportSource{}, false
+}
+
+// requiredModule returns the path of a require() call or of a variable
+// holding one, like mod after const mod = require("mod").
+func (w *walker) requiredModule(expr js_ast.Expr) (string, bool) {
+	if id, ok := expr.Data.(*js_ast.EIdentifier); ok {
+		path, ok := w.varRequire[w.resolveRef(id.Ref)]
+		return path, ok
+	}
+	return w.extractRequire(expr)
+}
+
+// overrideModuleExports discards everything recorded so far because
+// module.exports is being replaced with a new value.
+func (w *walker) overrideModuleExports() {
+	w.moduleExportsOverridden = true
+	w.exports = make(map[string]struct{})
+	w.reexports = make(map[string]struct{})
+	w.reexportCasing = make(map[string]string)
+	w.definiteExports = make(map[string]struct{})
+	w.definiteReexports = make(map[string]struct{})
+	w.sources = make(map[string]ExportSource)
+	w.defaultIsModuleExports = false
+	w.defaultName = ""
+	w.hasUnknownExports = false
+}
+
+// defaultNameOf returns the name a module.exports value was declared under,
+// or "" for anonymous values.
+func (w *walker) defaultNameOf(value js_ast.Expr) string {
+	// The parser wraps class expressions in a purity annotation
+	if annotation, ok := value.Data.(*js_ast.EAnnotation); ok {
+		value = annotation.Value
+	}
+	switch v := value.Data.(type) {
+	case *js_ast.EIdentifier:
+		// var _default = function Foo() {} is named Foo, not _default
+		ref := w.resolveRef(v.Ref)
+		if fi, ok := w.varFunc[ref]; ok && fi.name != "" {
+			return fi.name
+		}
+		if class, ok := w.varClass[ref]; ok && class.Name != nil {
+			return w.symbolName(class.Name.Ref)
+		}
+		return w.symbolName(v.Ref)
+	case *js_ast.EFunction:
+		if v.Fn.Name != nil {
+			return w.symbolName(v.Fn.Name.Ref)
+		}
+	case *js_ast.EClass:
+		if v.Class.Name != nil {
+			return w.symbolName(v.Class.Name.Ref)
+		}
+	}
+	return ""
+}
+
+// hasDefault reports whether the module exposes a default export.
+func (w *walker) hasDefault() bool {
+	if _, ok := w.exports["default"]; ok {
+		return true
+	}
+	if _, ok := w.exports["__esModule"]; ok {
+		// Transpiled ESM only has a default if it assigns exports.default
+		return false
+	}
+	return w.defaultIsModuleExports
+}
+
+// conditionalNames returns the names that are not in definite, preserving order.
+func conditionalNames(names []string, definite map[string]struct{}) []string {
+	var result []string
+	for _, name := range names {
+		if _, ok := definite[name]; !ok {
+			result = append(result, name)
+		}
+	}
+	return result
 }
 
 // addReexport adds a reexport path.
 func (w *walker) addReexport(path string) {
+	if w.opts.NormalizeReexportPaths {
+		path = normalizeSpecifier(path)
+	}
+	if w.opts.CaseInsensitiveReexports {
+		key := strings.ToLower(path)
+		if original, ok := w.reexportCasing[key]; ok {
+			path = original
+		} else {
+			w.reexportCasing[key] = path
+		}
+	}
 	w.reexports[path] = struct{}{}
+	if w.conditionalDepth == 0 {
+		w.definiteReexports[path] = struct{}{}
+	}
+}
+
+// normalizeSpecifier cleans a relative module specifier. Other specifiers are
+// returned unchanged since their segments are meaningful to the resolver.
+func normalizeSpecifier(spec string) string {
+	if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") {
+		return spec
+	}
+	cleaned := path.Clean(spec)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return cleaned
+	}
+	return "./" + cleaned
+}
+
+// countPattern records that an export pattern was matched.
+func (w *walker) countPattern(category string) {
+	if w.opts.CollectStats {
+		w.stats[category]++
+	}
+}
+
+// addRequire records a require path.
+func (w *walker) addRequire(path string) {
+	if w.opts.CollectRequires {
+		w.requires[path] = struct{}{}
+	}
 }
 
 // sortedExports returns exports in insertion order (approximated by sorted order).
@@ -1485,6 +3944,11 @@ func (w *walker) sortedExports() []string {
 		result = append(result, name)
 	}
 	sort.Strings(result)
+	if w.opts.ESModuleMarkerLast {
+		if i := sort.SearchStrings(result, "__esModule"); i < len(result) && result[i] == "__esModule" {
+			result = append(append(result[:i], result[i+1:]...), "__esModule")
+		}
+	}
 	return result
 }
 
@@ -1500,3 +3964,29 @@ func (w *walker) sortedReexports() []string {
 	sort.Strings(result)
 	return result
 }
+
+// sortedResolvedRequires returns require.resolve() paths in sorted order.
+func (w *walker) sortedResolvedRequires() []string {
+	if len(w.resolvedRequires) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(w.resolvedRequires))
+	for path := range w.resolvedRequires {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// sortedRequires returns requires in sorted order.
+func (w *walker) sortedRequires() []string {
+	if len(w.requires) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(w.requires))
+	for path := range w.requires {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -1,9 +1,13 @@
 package cjsexports
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"sort"
 	"strings"
 	"testing"
+	"unicode/utf16"
 )
 
 // helper to parse and return sorted exports and reexports.
@@ -32,6 +36,14 @@ func assertReexports(t *testing.T, got []string, want string) {
 	}
 }
 
+func assertRequires(t *testing.T, got []string, want string) {
+	t.Helper()
+	gotStr := strings.Join(got, ",")
+	if gotStr != want {
+		t.Errorf("requires: got %q, want %q", gotStr, want)
+	}
+}
+
 // assertExportsUnordered compares exports ignoring order.
 func assertExportsUnordered(t *testing.T, got []string, want string) {
 	t.Helper()
@@ -664,3 +676,2932 @@ func TestModuleExportsObjectLiteral(t *testing.T) {
 	exports, _ := parseTest(t, source, Options{})
 	assertExportsUnordered(t, exports, "a,b,c,d")
 }
+
+// --- Test: module?.exports && (module.exports.foo = 1) guard ---
+func TestOptionalChainGuard(t *testing.T) {
+	source := `
+		module?.exports && (module.exports.foo = 1)
+		module?.exports && (exports.bar = 1)
+		Object.defineProperty(module?.exports, 'baz', { value: 1 })
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "bar,baz,foo")
+
+	// The guard can't be evaluated, so it must not hide the assignment
+	// when NODE_ENV evaluation is enabled.
+	exports, _ = parseTest(t, source, Options{NodeEnv: "production"})
+	assertExportsUnordered(t, exports, "bar,baz,foo")
+}
+
+// --- Test: module.exports = (require("./polyfill"), {...}) ---
+func TestModuleExportsCommaSideEffectRequire(t *testing.T) {
+	source := `
+		module.exports = (require("./polyfill"), { foo: 1 })
+	`
+	result, err := Parse(source, "index.cjs", Options{CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "foo")
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./polyfill")
+}
+
+// --- Test: module.exports = void require("./polyfill") || {...} ---
+func TestModuleExportsVoidRequireOr(t *testing.T) {
+	source := `
+		require("./setup")
+		module.exports = void require("./polyfill") || { foo: 1, bar: 2 }
+	`
+	result, err := Parse(source, "index.cjs", Options{CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExportsUnordered(t, result.Exports, "bar,foo")
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./polyfill,./setup")
+
+	// Requires are left empty unless requested.
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertRequires(t, result.Requires, "")
+}
+
+// --- Test: RetainAST exposes the module/exports symbols ---
+func TestRetainASTRefs(t *testing.T) {
+	source := `
+		module.exports.foo = 1
+		exports.bar = 2
+	`
+	result, err := Parse(source, "index.cjs", Options{RetainAST: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.AST == nil {
+		t.Fatal("expected AST to be retained")
+	}
+	if name := result.AST.Symbols[result.ModuleRef.InnerIndex].OriginalName; name != "module" {
+		t.Errorf("ModuleRef: got symbol %q, want %q", name, "module")
+	}
+	if name := result.AST.Symbols[result.ExportsRef.InnerIndex].OriginalName; name != "exports" {
+		t.Errorf("ExportsRef: got symbol %q, want %q", name, "exports")
+	}
+
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.AST != nil {
+		t.Error("expected AST to be dropped without RetainAST")
+	}
+}
+
+// --- Test: if (0) / if (1) literal guards ---
+func TestNumericLiteralCondition(t *testing.T) {
+	source := `
+		if (0) {
+			module.exports = { dead: 1 }
+		} else {
+			module.exports = { foo: 1 }
+		}
+		if (1) {
+			module.exports.bar = 1
+		} else {
+			module.exports.alsoDead = 1
+		}
+		if ("") {
+			module.exports.emptyString = 1
+		}
+	`
+	exports, _ := parseTest(t, source, Options{NodeEnv: "production"})
+	assertExportsUnordered(t, exports, "bar,foo")
+}
+
+// --- Test: exports.api = require("./impl").api selective forward ---
+func TestSelectiveRequireMemberExport(t *testing.T) {
+	source := `
+		exports.api = require("./impl").api
+		exports.other = require("./other")["thing"]
+		exports.local = 1
+	`
+	result, err := Parse(source, "index.cjs", Options{CollectRequires: true, TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExportsUnordered(t, result.Exports, "api,local,other")
+	// Selective forwards are not star reexports.
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./impl,./other")
+	if got := result.ExportSources["api"]; got != (ExportSource{Path: "./impl", Member: "api"}) {
+		t.Errorf("api source: got %+v", got)
+	}
+	if got := result.ExportSources["other"]; got != (ExportSource{Path: "./other", Member: "thing"}) {
+		t.Errorf("other source: got %+v", got)
+	}
+	if _, ok := result.ExportSources["local"]; ok {
+		t.Errorf("local export should have no source")
+	}
+
+	// Without the options only the names are reported.
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExportsUnordered(t, result.Exports, "api,local,other")
+	if result.ExportSources != nil || result.Requires != nil {
+		t.Errorf("expected no sources or requires, got %v %v", result.ExportSources, result.Requires)
+	}
+}
+
+// --- Test: SynthesizeDefault for module.exports = {...} ---
+func TestSynthesizeDefault(t *testing.T) {
+	source := `
+		module.exports = { foo: 1, bar: 2 }
+	`
+	result, err := Parse(source, "index.cjs", Options{SynthesizeDefault: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExportsUnordered(t, result.Exports, "bar,foo")
+	if !result.HasDefault {
+		t.Error("expected HasDefault")
+	}
+
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.HasDefault {
+		t.Error("expected no HasDefault without SynthesizeDefault")
+	}
+
+	// Modules marked __esModule only have a default if they export one.
+	source = `
+		module.exports = { __esModule: true, foo: 1 }
+	`
+	result, err = Parse(source, "index.cjs", Options{SynthesizeDefault: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.HasDefault {
+		t.Error("expected no HasDefault for __esModule")
+	}
+}
+
+// --- Test: module.exports = Object.assign(module.exports, ...) self-augment ---
+func TestModuleExportsSelfAssign(t *testing.T) {
+	source := `
+		exports.x = 1
+		module.exports = Object.assign(module.exports, require("./a"), require("./b"), { y: 2 })
+		exports.z = 3
+	`
+	exports, reexports := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "x,y,z")
+	assertReexportsUnordered(t, reexports, "./a,./b")
+
+	source = `
+		exports.x = 1
+		module.exports = Object.assign(exports, require("./a"))
+	`
+	exports, reexports = parseTest(t, source, Options{})
+	assertExports(t, exports, "x")
+	assertReexports(t, reexports, "./a")
+}
+
+// --- Test: module.exports.default after module.exports = function ---
+func TestModuleExportsDefaultAfterOverride(t *testing.T) {
+	source := `
+		exports.dropped = 1
+		module.exports = function () {}
+		module.exports.default = module.exports
+	`
+	result, err := Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "default")
+	if !result.HasDefault {
+		t.Error("expected HasDefault")
+	}
+}
+
+// --- Test: leading @exports directive comment ---
+func TestExportDirectives(t *testing.T) {
+	source := `// generated file
+/**
+ * @exports foo, bar
+ * @exports baz
+ */
+module.exports = load()
+/** @exports ignored */
+`
+	exports, _ := parseTest(t, source, Options{HonorExportDirectives: true})
+	assertExportsUnordered(t, exports, "bar,baz,foo")
+
+	exports, _ = parseTest(t, source, Options{})
+	assertExports(t, exports, "")
+}
+
+// --- Test: require.main === module CLI guard ---
+func TestRequireMainGuard(t *testing.T) {
+	source := `
+		function api() {}
+		if (require.main === module) {
+			module.exports = { cliOnly: 1 }
+		} else {
+			module.exports = { run: api }
+		}
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExports(t, exports, "run")
+
+	exports, _ = parseTest(t, source, Options{RequireMainIsModule: true})
+	assertExports(t, exports, "cliOnly")
+
+	source = `
+		if (module !== require.main) {
+			exports.lib = 1
+		} else {
+			exports.cli = 1
+		}
+	`
+	exports, _ = parseTest(t, source, Options{NodeEnv: "production"})
+	assertExports(t, exports, "lib")
+}
+
+// --- Test: destructuring assignment into exports members ---
+func TestDestructuringAssignment(t *testing.T) {
+	source := `
+		[exports.a, , module.exports.b = 2, ...exports.rest] = fn();
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "a,b,rest")
+
+	source = `
+		var e = exports;
+		({ x: exports.x, y: module.exports["y"], z: e.z = 1, ...exports.others } = obj);
+	`
+	exports, _ = parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "others,x,y,z")
+}
+
+// --- Test: DefaultName for module.exports = named value ---
+func TestDefaultName(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`function App() {}; module.exports = App`, "App"},
+		{`module.exports = function render() {}`, "render"},
+		{`module.exports = class Store {}`, "Store"},
+		{`module.exports = function () {}`, ""},
+		{`module.exports = { foo: 1 }`, ""},
+		{`module.exports = Named; module.exports = {}`, ""},
+	}
+	for _, tt := range tests {
+		result, err := Parse(tt.source, "index.cjs", Options{})
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if result.DefaultName != tt.want {
+			t.Errorf("%s: got DefaultName %q, want %q", tt.source, result.DefaultName, tt.want)
+		}
+	}
+}
+
+// --- Test: module.exports = require(...) then augmented ---
+func TestAugmentedReexport(t *testing.T) {
+	source := `
+		module.exports = require("./a")
+		module.exports.extra = 1
+	`
+	exports, reexports := parseTest(t, source, Options{})
+	assertExports(t, exports, "extra")
+	assertReexports(t, reexports, "./a")
+}
+
+// --- Test: source starting with a shebang line ---
+func TestShebang(t *testing.T) {
+	source := "#!/usr/bin/env node\n" + `
+		function run() {}
+		exports.run = run
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExports(t, exports, "run")
+}
+
+// --- Test: ResolveReexport merges names with local precedence ---
+func TestResolveReexportDedupe(t *testing.T) {
+	source := `
+		module.exports = { ...require("./base"), foo: 1 }
+	`
+	resolve := func(importer, path string) (string, *Result, bool) {
+		if path == "./base" {
+			return path, &Result{Exports: []string{"bar", "foo"}}, true
+		}
+		return "", nil, false
+	}
+	result, err := Parse(source, "index.cjs", Options{ResolveReexport: resolve, TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "bar,foo")
+	assertReexports(t, result.Reexports, "./base")
+	if _, ok := result.ExportSources["foo"]; ok {
+		t.Error("local foo should take precedence over ./base")
+	}
+	if got := result.ExportSources["bar"]; got != (ExportSource{Path: "./base", Member: "bar"}) {
+		t.Errorf("bar source: got %+v", got)
+	}
+
+	// Unresolvable reexports are left as-is.
+	result, err = Parse(`module.exports = require("./missing")`, "index.cjs", Options{ResolveReexport: resolve})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "")
+	assertReexports(t, result.Reexports, "./missing")
+}
+
+// --- Test: webpack 5 __webpack_exports__ runtime ---
+func TestWebpackExports(t *testing.T) {
+	source := `
+		(() => {
+			"use strict";
+			var __webpack_modules__ = {
+				1: (module, __webpack_exports__, __webpack_require__) => {
+					__webpack_require__.d(__webpack_exports__, { internal: () => internal });
+				}
+			};
+			function __webpack_require__(id) {}
+			var __webpack_exports__ = {};
+			__webpack_require__.r(__webpack_exports__);
+			__webpack_require__.d(__webpack_exports__, {
+				"default": () => __WEBPACK_DEFAULT_EXPORT__,
+				foo: () => foo,
+				bar: () => bar
+			});
+			__webpack_exports__.extra = 1;
+			module.exports = __webpack_exports__;
+		})();
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "__esModule,bar,default,extra,foo")
+
+	// libraryTarget commonjs2 returns the exports object from the IIFE
+	result, err := Parse(`
+		module.exports = (() => {
+			"use strict";
+			var __webpack_modules__ = {};
+			function __webpack_require__(id) {}
+			var __webpack_exports__ = {};
+			__webpack_require__.r(__webpack_exports__);
+			__webpack_require__.d(__webpack_exports__, { foo: () => foo });
+			return __webpack_exports__;
+		})();
+	`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "__esModule,foo")
+	if result.HasUnknownExports {
+		t.Error("expected the webpack exports to be known")
+	}
+
+	// Without the runtime calls the returned object is still unknown
+	result, err = Parse(`
+		module.exports = (() => {
+			var __webpack_exports__ = build();
+			return __webpack_exports__;
+		})();
+	`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if !result.HasUnknownExports {
+		t.Error("expected HasUnknownExports")
+	}
+}
+
+// --- Test: exports.x = exports.x || function(){} memoization ---
+func TestExportsMemoizedInit(t *testing.T) {
+	source := `
+		exports.getThing = exports.getThing || function getThing() {
+			exports.phantom = 1;
+			module.exports.alsoPhantom = 2;
+			return {};
+		};
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExports(t, exports, "getThing")
+
+	exports, _ = parseTest(t, source, Options{CallMode: true})
+	assertExports(t, exports, "getThing")
+}
+
+// --- Test: CollectStats counts matched patterns ---
+func TestCollectStats(t *testing.T) {
+	source := `
+		Object.defineProperty(exports, "__esModule", { value: true });
+		Object.defineProperty(exports, "a", { get: function () { return 1; } });
+		exports.b = 1;
+		module.exports.c = 2;
+		Object.assign(exports, { d: 1 });
+		__exportStar(require("./e"), exports);
+	`
+	result, err := Parse(source, "index.cjs", Options{CollectStats: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := map[string]int{
+		"defineProperty":        2,
+		"exportsProperty":       1,
+		"moduleExportsProperty": 1,
+		"objectAssign":          1,
+		"exportStar":            1,
+	}
+	if len(result.Stats) != len(want) {
+		t.Errorf("stats: got %v, want %v", result.Stats, want)
+	}
+	for k, v := range want {
+		if result.Stats[k] != v {
+			t.Errorf("stats[%q]: got %d, want %d", k, result.Stats[k], v)
+		}
+	}
+
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.Stats != nil {
+		t.Errorf("expected no stats, got %v", result.Stats)
+	}
+}
+
+// --- Test: module.exports = new SomeClass() ---
+func TestModuleExportsNewInstance(t *testing.T) {
+	source := `
+		exports.dropped = 1
+		class Client {
+			static create() {}
+			timeout = 100
+			constructor() {
+				this.host = "localhost"
+				this.port = 80
+			}
+			connect() {}
+		}
+		module.exports = new Client()
+	`
+	result, err := Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "")
+	if !result.HasDefault {
+		t.Error("expected HasDefault")
+	}
+
+	exports, _ := parseTest(t, source, Options{InstanceExports: true})
+	assertExportsUnordered(t, exports, "host,port,timeout")
+
+	// Classes that aren't defined locally contribute nothing.
+	source = `
+		const EventEmitter = require("events")
+		module.exports = new EventEmitter()
+	`
+	exports, _ = parseTest(t, source, Options{InstanceExports: true})
+	assertExports(t, exports, "")
+}
+
+// --- Test: chained assignment mixing bracket and dot members ---
+func TestMixedChainedAssignment(t *testing.T) {
+	source := `
+		exports["default"] = exports.foo = module.exports["bar"] = value;
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "bar,default,foo")
+}
+
+// --- Test: loader inferred from the filename extension ---
+func TestExtensionLoaders(t *testing.T) {
+	tsSource := `
+		const value: number = 1
+		export = { value }
+		exports.typed = <any>value
+	`
+	// .ts files are parsed as TypeScript by default.
+	if _, err := Parse(tsSource, "index.ts", Options{}); err != nil {
+		t.Errorf("expected .ts to parse as TypeScript: %v", err)
+	}
+	if _, err := Parse(tsSource, "index.js", Options{}); err == nil {
+		t.Error("expected .js to reject TypeScript syntax")
+	}
+
+	jsxSource := `
+		exports.App = () => <div />
+	`
+	result, err := Parse(jsxSource, "App.jsx", Options{})
+	if err != nil {
+		t.Fatalf("expected .jsx to parse as JSX: %v", err)
+	}
+	assertExports(t, result.Exports, "App")
+
+	// Callers can remap extensions.
+	result, err = Parse(jsxSource, "App.js", Options{ExtensionLoaders: map[string]Loader{".js": LoaderJSX}})
+	if err != nil {
+		t.Fatalf("expected remapped .js to parse as JSX: %v", err)
+	}
+	assertExports(t, result.Exports, "App")
+
+	// An explicit loader wins over the extension.
+	if _, err := Parse(jsxSource, "App.ts", Options{Loader: LoaderTSX}); err != nil {
+		t.Errorf("expected explicit TSX loader: %v", err)
+	}
+}
+
+// --- Test: Object.assign(exports, require("./a").default) ---
+func TestObjectAssignRequireMember(t *testing.T) {
+	source := `
+		exports.own = 1
+		Object.assign(exports, require("./a").default, require("./b")["helpers"])
+		module.exports.spread = { ...require("./c").default }
+	`
+	exports, reexports := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "own,spread")
+	assertReexportsUnordered(t, reexports, "./a,./b")
+
+	source = `
+		module.exports = { ...require("./c").default, local: 1 }
+	`
+	exports, reexports = parseTest(t, source, Options{})
+	assertExports(t, exports, "local")
+	assertReexports(t, reexports, "./c")
+}
+
+// --- Test: chain of objects spreading each other ---
+func TestSpreadChain(t *testing.T) {
+	source := `
+		const a = { ...require("./base"), a: 1 }
+		const b = { ...a, b: 1 }
+		const c = { ...b, ...a, c: 1 }
+		a.late = 1
+		module.exports = c
+	`
+	exports, reexports := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "a,b,c")
+	assertReexports(t, reexports, "./base")
+
+	// Properties added to a parent after it was spread are not inherited
+	exports, _ = parseTest(t, `
+		var base = { a: 1 };
+		var o = { ...base };
+		base.b = 2;
+		module.exports = o;
+	`, Options{})
+	assertExports(t, exports, "a")
+
+	exports, _ = parseTest(t, `
+		var base = { a: 1 };
+		var o = { ...base };
+		base.b = 2;
+		o.c = 3;
+		module.exports = { ...o, ...base };
+	`, Options{})
+	assertExportsUnordered(t, exports, "a,b,c")
+}
+
+// spreadChainSource builds a module with a chain of n objects each spreading
+// the previous one.
+func spreadChainSource(n int) string {
+	var sb strings.Builder
+	sb.WriteString("const o0 = { p0: 0 }\n")
+	for i := 1; i < n; i++ {
+		fmt.Fprintf(&sb, "const o%d = { ...o%d, p%d: %d }\n", i, i-1, i, i)
+	}
+	fmt.Fprintf(&sb, "module.exports = o%d\n", n-1)
+	return sb.String()
+}
+
+func BenchmarkSpreadChain(b *testing.B) {
+	source := spreadChainSource(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		result, err := Parse(source, "index.cjs", Options{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(result.Exports) != 100 {
+			b.Fatalf("expected 100 exports, got %d", len(result.Exports))
+		}
+	}
+}
+
+// --- Test: exports assigned in class static blocks ---
+func TestClassStaticBlock(t *testing.T) {
+	source := `
+		class C {
+			static {
+				module.exports.foo = 1;
+				exports.bar = 2;
+			}
+			method() {
+				exports.notRun = 3;
+			}
+		}
+		const D = class {
+			static { exports.baz = 4; }
+		}
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExportsUnordered(t, exports, "bar,baz,foo")
+}
+
+// --- Test: Result.Empty ---
+func TestResultEmpty(t *testing.T) {
+	tests := []struct {
+		source string
+		opts   Options
+		want   bool
+	}{
+		{``, Options{}, true},
+		{`require("./side-effect")`, Options{CollectRequires: true}, true},
+		{`exports.foo = 1`, Options{}, false},
+		{`module.exports = require("./a")`, Options{}, false},
+		{`module.exports = new Thing()`, Options{}, false},
+		{`module.exports = {}`, Options{SynthesizeDefault: true}, false},
+	}
+	for _, tt := range tests {
+		result, err := Parse(tt.source, "index.cjs", tt.opts)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if got := result.Empty(); got != tt.want {
+			t.Errorf("%q: got Empty() %v, want %v", tt.source, got, tt.want)
+		}
+	}
+
+	var nilResult *Result
+	if !nilResult.Empty() {
+		t.Error("expected nil Result to be empty")
+	}
+}
+
+// --- Test: Result.Empty covers every signal field ---
+func TestResultEmptyFields(t *testing.T) {
+	// Every Result field is listed here, so a new field must be classified
+	// and, if it signals that something is exported, checked by Empty
+	signal := map[string]bool{
+		"Exports":           true,
+		"Reexports":         true,
+		"ExportCount":       true,
+		"ReexportCount":     true,
+		"HasDefault":        true,
+		"HasUnknownExports": true,
+	}
+	metadata := map[string]bool{
+		"DefaultName":          true,
+		"Requires":             true,
+		"ResolvedRequires":     true,
+		"ReexportKinds":        true,
+		"ExportSources":        true,
+		"ReexportedNames":      true,
+		"AST":                  true,
+		"ModuleRef":            true,
+		"ExportsRef":           true,
+		"ConditionalExports":   true,
+		"ConditionalReexports": true,
+		"Warnings":             true,
+		"Stats":                true,
+		"LineCount":            true,
+		"ByteCount":            true,
+	}
+	typ := reflect.TypeOf(Result{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := typ.Field(i).Name
+		if !signal[name] && !metadata[name] {
+			t.Errorf("Result.%s is not classified as a signal or metadata field", name)
+			continue
+		}
+		var result Result
+		value := reflect.ValueOf(&result).Elem().Field(i)
+		switch value.Kind() {
+		case reflect.Bool:
+			value.SetBool(true)
+		case reflect.Int:
+			value.SetInt(1)
+		case reflect.String:
+			value.SetString("x")
+		case reflect.Slice:
+			value.Set(reflect.MakeSlice(value.Type(), 1, 1))
+		case reflect.Map:
+			value.Set(reflect.MakeMap(value.Type()))
+			value.SetMapIndex(reflect.Zero(value.Type().Key()), reflect.Zero(value.Type().Elem()))
+		case reflect.Ptr:
+			value.Set(reflect.New(value.Type().Elem()))
+		case reflect.Struct:
+			// ModuleRef and ExportsRef have no meaningful non-zero value
+			// outside a retained AST
+			continue
+		default:
+			t.Fatalf("Result.%s has unhandled kind %s", name, value.Kind())
+		}
+		if got := result.Empty(); got == signal[name] {
+			t.Errorf("Result with only %s set: got Empty() %v", name, got)
+		}
+	}
+}
+
+// --- Test: module.exports = typeof-gated ternary ---
+func TestModuleExportsTypeofTernary(t *testing.T) {
+	source := `
+		module.exports = typeof foo !== "undefined" ? foo : require("./fallback")
+	`
+	_, reexports := parseTest(t, source, Options{})
+	assertReexports(t, reexports, "./fallback")
+
+	source = `
+		module.exports = typeof module !== "undefined" ? { node: 1 } : require("./browser")
+	`
+	exports, reexports := parseTest(t, source, Options{})
+	assertExports(t, exports, "node")
+	assertReexports(t, reexports, "")
+
+	source = `
+		module.exports = typeof exports === "undefined" ? window.lib : { ...require("./impl"), extra: 1 }
+	`
+	exports, reexports = parseTest(t, source, Options{})
+	assertExports(t, exports, "extra")
+	assertReexports(t, reexports, "./impl")
+}
+
+// --- Test: __exportStar destructured from tslib ---
+func TestDestructuredExportStar(t *testing.T) {
+	source := `
+		const { __exportStar } = require("tslib");
+		__exportStar(require("./x"), exports);
+	`
+	_, reexports := parseTest(t, source, Options{})
+	assertReexports(t, reexports, "./x")
+
+	source = `
+		const { __exportStar: es } = require("tslib");
+		es(require("./y"), exports);
+		(0, es)(require("./z"), exports);
+	`
+	_, reexports = parseTest(t, source, Options{})
+	assertReexportsUnordered(t, reexports, "./y,./z")
+}
+
+// --- Test: panics during analysis are returned as errors ---
+func TestPanicRecover(t *testing.T) {
+	source := `module.exports = require("./boom")`
+	opts := Options{
+		ResolveReexport: func(importer, path string) (string, *Result, bool) {
+			panic("resolver failed for " + path)
+		},
+	}
+	result, err := Parse(source, "boom.cjs", opts)
+	if result != nil {
+		t.Errorf("expected nil result, got %+v", result)
+	}
+	panicErr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("expected *PanicError, got %T: %v", err, err)
+	}
+	if panicErr.Filename != "boom.cjs" || panicErr.Value != "resolver failed for ./boom" {
+		t.Errorf("unexpected panic error: %+v", panicErr)
+	}
+	if !strings.Contains(panicErr.Error(), "boom.cjs") || panicErr.Stack == "" {
+		t.Errorf("expected filename and stack in error: %q", panicErr.Error())
+	}
+
+	opts.DisablePanicRecover = true
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic to propagate with DisablePanicRecover")
+		}
+	}()
+	Parse(source, "boom.cjs", opts)
+}
+
+// --- Test: module.exports = { default: X, ...named } ---
+func TestModuleExportsObjectDefaultKey(t *testing.T) {
+	source := `
+		const impl = {}
+		module.exports = { default: impl, foo, bar, ...require("./more") }
+	`
+	result, err := Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExportsUnordered(t, result.Exports, "bar,default,foo")
+	assertReexports(t, result.Reexports, "./more")
+	if !result.HasDefault {
+		t.Error("expected HasDefault")
+	}
+
+	result, err = Parse(`module.exports = { foo, bar }`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.HasDefault {
+		t.Error("expected no HasDefault without a default key")
+	}
+}
+
+// --- Test: subpath and scoped specifiers round-trip unchanged ---
+func TestSubpathReexports(t *testing.T) {
+	source := `
+		module.exports = {
+			...require("lib/feature"),
+			...require("@scope/pkg/sub"),
+			...require("@scope/pkg"),
+			...require("./local/../util/"),
+			...require("../parent/./file.js"),
+		}
+	`
+	_, reexports := parseTest(t, source, Options{})
+	assertReexportsUnordered(t, reexports, "../parent/./file.js,./local/../util/,@scope/pkg,@scope/pkg/sub,lib/feature")
+
+	_, reexports = parseTest(t, source, Options{NormalizeReexportPaths: true})
+	assertReexportsUnordered(t, reexports, "../parent/file.js,./util,@scope/pkg,@scope/pkg/sub,lib/feature")
+}
+
+// --- Test: ParseMany ---
+
+func TestParseMany(t *testing.T) {
+	results, errs := ParseMany(map[string]string{
+		"a.js":   `exports.a = 1;`,
+		"b.js":   `module.exports = require("./a");`,
+		"bad.js": `exports.x = ;`,
+		"c.js":   `exports.c = 1;`,
+	}, Options{})
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	assertExports(t, results["a.js"].Exports, "a")
+	assertReexports(t, results["b.js"].Reexports, "./a")
+	assertExports(t, results["c.js"].Exports, "c")
+	if _, ok := results["bad.js"]; ok {
+		t.Errorf("expected no result for bad.js")
+	}
+	if len(errs) != 1 || errs["bad.js"] == nil {
+		t.Fatalf("expected a single error for bad.js, got %v", errs)
+	}
+	var perr *ParseError
+	if !errors.As(errs["bad.js"], &perr) || len(perr.Messages) == 0 {
+		t.Errorf("expected ParseError with messages, got %v", errs["bad.js"])
+	}
+}
+
+func parseManySources() map[string]string {
+	sources := make(map[string]string, 100)
+	for i := 0; i < 100; i++ {
+		sources[fmt.Sprintf("mod%d.js", i)] = fmt.Sprintf(
+			"exports.a%d = 1;\nmodule.exports.b = require(\"./dep%d\");\nObject.defineProperty(exports, \"c\", { value: 1 });\n", i, i)
+	}
+	return sources
+}
+
+func BenchmarkParseMany(b *testing.B) {
+	sources := parseManySources()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ParseMany(sources, Options{})
+	}
+}
+
+func BenchmarkParseLoop(b *testing.B) {
+	sources := parseManySources()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for filename, source := range sources {
+			Parse(source, filename, Options{})
+		}
+	}
+}
+
+// --- Test: Babel _export getter helper ---
+
+func TestBabelExportHelper(t *testing.T) {
+	exports, _ := parseTest(t, `
+"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+function _export(target, all) {
+    for (var name in all) Object.defineProperty(target, name, { enumerable: true, get: all[name] });
+}
+_export(exports, {
+    bar: function() { return bar; },
+    foo: function() { return foo; }
+});
+const foo = 1, bar = 2;
+`, Options{})
+	assertExports(t, exports, "__esModule,bar,foo")
+}
+
+func TestBabelExportsHelperName(t *testing.T) {
+	exports, _ := parseTest(t, `
+_exports(exports, {
+    a: function() { return a; },
+    b: () => b
+});
+`, Options{})
+	assertExports(t, exports, "a,b")
+}
+
+func TestBabelExportHelperIgnoresOtherTargets(t *testing.T) {
+	exports, _ := parseTest(t, `
+_export(other, { a: function() { return a; } });
+`, Options{})
+	assertExports(t, exports, "")
+}
+
+// --- Test: module.exports ||= {} does not override ---
+
+func TestModuleExportsLogicalOrAssign(t *testing.T) {
+	exports, _ := parseTest(t, `
+exports.bar = 1;
+module.exports ||= {};
+module.exports.foo = 1;
+`, Options{})
+	assertExports(t, exports, "bar,foo")
+}
+
+func TestModuleExportsOrSelf(t *testing.T) {
+	exports, _ := parseTest(t, `
+exports.bar = 1;
+module.exports = module.exports || {};
+module.exports.foo = 1;
+`, Options{})
+	assertExports(t, exports, "bar,foo")
+}
+
+// --- Test: PureReexport ---
+
+func TestPureReexport(t *testing.T) {
+	tests := []struct {
+		source string
+		path   string
+		ok     bool
+	}{
+		{`module.exports = require("./impl");`, "./impl", true},
+		{`__exportStar(require("./a"), exports);`, "./a", true},
+		{`module.exports = require("./impl"); module.exports.extra = 1;`, "", false},
+		{`__exportStar(require("./a"), exports); __exportStar(require("./b"), exports);`, "", false},
+		{`exports.foo = 1;`, "", false},
+	}
+	for _, tt := range tests {
+		result, err := Parse(tt.source, "index.cjs", Options{})
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		path, ok := result.PureReexport()
+		if path != tt.path || ok != tt.ok {
+			t.Errorf("%s: got (%q, %v), want (%q, %v)", tt.source, path, ok, tt.path, tt.ok)
+		}
+	}
+	var nilResult *Result
+	if _, ok := nilResult.PureReexport(); ok {
+		t.Errorf("expected nil Result to not be a pure reexport")
+	}
+}
+
+// --- Test: try/catch/finally ---
+
+func TestTryCatchFinallyExports(t *testing.T) {
+	exports, reexports := parseTest(t, `
+try {
+    exports.native = require("./native");
+} catch (e) {
+    exports.fallback = 1;
+} finally {
+    exports.always = 1;
+}
+`, Options{})
+	assertExports(t, exports, "always,fallback,native")
+	assertReexports(t, reexports, "")
+}
+
+func TestTryFinallyModuleExportsMarkConditional(t *testing.T) {
+	result, err := Parse(`
+var api = { a: 1 };
+try {
+    exports.maybe = 1;
+    __exportStar(require("./optional"), exports);
+} catch (e) {
+} finally {
+    exports.always = 1;
+    __exportStar(require("./core"), exports);
+}
+`, "index.cjs", Options{MarkConditional: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "always,maybe")
+	assertReexports(t, result.Reexports, "./core,./optional")
+	assertExports(t, result.ConditionalExports, "maybe")
+	assertReexports(t, result.ConditionalReexports, "./optional")
+}
+
+func TestFinallyModuleExportsUnconditional(t *testing.T) {
+	result, err := Parse(`
+var api = { a: 1, b: 2 };
+try {
+    init();
+} finally {
+    module.exports = api;
+}
+`, "index.cjs", Options{MarkConditional: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b")
+	assertExports(t, result.ConditionalExports, "")
+}
+
+// --- Test: deep member reexport ---
+
+func TestModuleExportsDeepRequireMember(t *testing.T) {
+	_, reexports := parseTest(t, `module.exports = require("./a").b.c;`, Options{})
+	assertReexports(t, reexports, "./a")
+
+	_, reexports = parseTest(t, `module.exports = require("./a")["b"].c;`, Options{})
+	assertReexports(t, reexports, "./a")
+
+	exports, reexports := parseTest(t, `module.exports = foo.b.c;`, Options{})
+	assertExports(t, exports, "")
+	assertReexports(t, reexports, "")
+}
+
+// --- Test: literal if conditions fold without NodeEnv ---
+
+func TestLiteralIfConditions(t *testing.T) {
+	exports, _ := parseTest(t, `
+if (false) { exports.x = 1; } else { exports.y = 1; }
+if (true) { exports.a = 1; } else { exports.b = 1; }
+if (0) { exports.zero = 1; }
+if (!1) { exports.notOne = 1; }
+if ("") { exports.empty = 1; }
+while (false) { exports.loop = 1; }
+`, Options{})
+	assertExports(t, exports, "a,y")
+}
+
+// --- Test: lazy getter barrel ---
+
+func TestLazyGetterBarrel(t *testing.T) {
+	result, err := Parse(`
+module.exports = {
+    get Foo() { return require("./Foo"); },
+    get Bar() { return require("./Bar").Bar; },
+    get computed() { return compute(); },
+    plain: 1
+};
+`, "index.cjs", Options{TrackExportSources: true, CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "Bar,Foo,computed,plain")
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./Bar,./Foo")
+	want := map[string]ExportSource{
+		"Foo": {Path: "./Foo"},
+		"Bar": {Path: "./Bar", Member: "Bar"},
+	}
+	if len(result.ExportSources) != len(want) {
+		t.Fatalf("expected %d sources, got %v", len(want), result.ExportSources)
+	}
+	for name, src := range want {
+		if result.ExportSources[name] != src {
+			t.Errorf("source of %s: got %+v, want %+v", name, result.ExportSources[name], src)
+		}
+	}
+}
+
+// --- Test: OnExport / OnReexport callbacks ---
+
+func TestOnExportCallbacks(t *testing.T) {
+	source := `
+exports.dropped = 1;
+module.exports = require("./x");
+module.exports.b = 1;
+module.exports.a = 2;
+`
+	var names, paths []string
+	opts := Options{
+		OnExport:   func(name string) { names = append(names, name) },
+		OnReexport: func(path string) { paths = append(paths, path) },
+	}
+	result, err := Parse(source, "index.cjs", opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// Without CollectResult the callbacks aren't made in sorted order
+	assertExportsUnordered(t, names, "a,b")
+	assertReexports(t, paths, "./x")
+	if result.Exports != nil || result.Reexports != nil {
+		t.Errorf("expected nil Exports and Reexports, got %v %v", result.Exports, result.Reexports)
+	}
+
+	names, paths = nil, nil
+	opts.CollectResult = true
+	result, err = Parse(source, "index.cjs", opts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, names, "a,b")
+	assertExports(t, result.Exports, "a,b")
+	assertReexports(t, result.Reexports, "./x")
+}
+
+// --- Test: Object.assign with a default key sets HasDefault ---
+
+func TestObjectAssignDefaultKeyHasDefault(t *testing.T) {
+	result, err := Parse(`
+Object.defineProperty(exports, "__esModule", { value: true });
+Object.assign(exports, { default: main, foo: 1 }, require("./y"));
+`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "__esModule,default,foo")
+	assertReexports(t, result.Reexports, "./y")
+	if !result.HasDefault {
+		t.Errorf("expected HasDefault to be true")
+	}
+}
+
+// --- Test: require.resolve ---
+
+func TestRequireResolve(t *testing.T) {
+	result, err := Parse(`
+const wasm = require.resolve("./lib.wasm");
+const data = fs.readFileSync(require.resolve("./data.json"));
+exports.impl = require("./impl");
+module.exports.path = require.resolve("./path");
+`, "index.cjs", Options{CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "impl,path")
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./impl")
+	assertRequires(t, result.ResolvedRequires, "./data.json,./lib.wasm,./path")
+
+	result, err = Parse(`require.resolve("./a");`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.ResolvedRequires != nil {
+		t.Errorf("expected no ResolvedRequires without CollectRequires, got %v", result.ResolvedRequires)
+	}
+}
+
+// --- Test: chained alias assignments ---
+
+func TestChainedExportsAliases(t *testing.T) {
+	exports, _ := parseTest(t, `
+var b, c;
+var a = b = c = exports;
+a.foo = 1;
+b.bar = 1;
+c.baz = 1;
+`, Options{})
+	assertExports(t, exports, "bar,baz,foo")
+}
+
+func TestChainedModuleExportsAliases(t *testing.T) {
+	exports, _ := parseTest(t, `
+var y;
+var x = y = module.exports;
+x.foo = 1;
+y.bar = 1;
+`, Options{})
+	assertExports(t, exports, "bar,foo")
+}
+
+// --- Test: ReportUnknownPatterns ---
+
+func TestReportUnknownPatterns(t *testing.T) {
+	source := `module.exports = createApi();`
+	result, err := Parse(source, "index.cjs", Options{ReportUnknownPatterns: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(result.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", result.Warnings)
+	}
+	warning := result.Warnings[0]
+	if !strings.Contains(warning.Text, "ECall") {
+		t.Errorf("expected warning to name the node kind, got %q", warning.Text)
+	}
+	if got, want := int(warning.Loc.Start), strings.Index(source, "createApi"); got != want {
+		t.Errorf("expected warning at offset %d, got %d", want, got)
+	}
+
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.Warnings != nil {
+		t.Errorf("expected no warnings by default, got %v", result.Warnings)
+	}
+}
+
+func TestReportUnknownPatternsRecognized(t *testing.T) {
+	for _, source := range []string{
+		`module.exports = { a: 1 };`,
+		`module.exports = require("./a");`,
+		`module.exports = function() {};`,
+		`module.exports = class Foo {};`,
+		`module.exports = "value";`,
+		`var o = { a: 1 }; module.exports = o;`,
+	} {
+		result, err := Parse(source, "index.cjs", Options{ReportUnknownPatterns: true})
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if len(result.Warnings) != 0 {
+			t.Errorf("%s: expected no warnings, got %v", source, result.Warnings)
+		}
+	}
+}
+
+// --- Benchmark: annotation scan on large sources without the pattern ---
+
+func BenchmarkScanAnnotationPatternNoMarker(b *testing.B) {
+	source := strings.Repeat("exports.foo = function (a, b) { return a || b; };\n", 50000)
+	w := &walker{exports: make(map[string]struct{}), definiteExports: make(map[string]struct{})}
+	b.SetBytes(int64(len(source)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.scanAnnotationPattern(source, "index.cjs")
+	}
+}
+
+// --- Test: CaseInsensitiveReexports ---
+
+func TestCaseInsensitiveReexports(t *testing.T) {
+	source := `
+__exportStar(require("./Foo"), exports);
+__exportStar(require("./foo"), exports);
+__exportStar(require("./bar"), exports);
+exports.Name = 1;
+exports.name = 2;
+`
+	exports, reexports := parseTest(t, source, Options{CaseInsensitiveReexports: true})
+	assertReexports(t, reexports, "./Foo,./bar")
+	assertExports(t, exports, "Name,name")
+
+	_, reexports = parseTest(t, source, Options{})
+	assertReexports(t, reexports, "./Foo,./bar,./foo")
+}
+
+// --- Test: Object.create with descriptors ---
+
+func TestModuleExportsObjectCreateDescriptors(t *testing.T) {
+	exports, reexports := parseTest(t, `
+module.exports = Object.create(null, {
+    version: { value: "1.0.0", enumerable: true },
+    current: { get: function() { return state; } },
+    "quoted": { value: 1 },
+    setterOnly: { set: function(v) {} }
+});
+`, Options{})
+	assertExports(t, exports, "current,quoted,version")
+	assertReexports(t, reexports, "")
+}
+
+func TestModuleExportsObjectCreateRequireProto(t *testing.T) {
+	exports, reexports := parseTest(t, `
+module.exports = Object.create(require("./base"), {
+    extra: { value: 1 }
+});
+`, Options{})
+	assertExports(t, exports, "extra")
+	assertReexports(t, reexports, "./base")
+}
+
+// --- Test: comma-separated export assignments ---
+
+func TestCommaStatementExports(t *testing.T) {
+	exports, _ := parseTest(t, `
+module.exports.a = 1, module.exports.b = 2;
+exports.c = 1, exports.d = 2, exports.e = 3;
+`, Options{})
+	assertExports(t, exports, "a,b,c,d,e")
+}
+
+// --- Test: ParseSafe ---
+
+func TestParseSafe(t *testing.T) {
+	result := ParseSafe(`exports.foo = 1;`, "index.cjs", Options{})
+	assertExports(t, result.Exports, "foo")
+	if len(result.Warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", result.Warnings)
+	}
+
+	result = ParseSafe(`exports.foo = ;`, "index.cjs", Options{})
+	if result == nil || !result.Empty() {
+		t.Fatalf("expected empty result for invalid source, got %+v", result)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Text == "" {
+		t.Errorf("expected one warning describing the parse error, got %v", result.Warnings)
+	}
+
+	result = ParseSafe(`module.exports = require("./a");`, "index.cjs", Options{
+		DisablePanicRecover: true,
+		ResolveReexport: func(importer, path string) (string, *Result, bool) {
+			panic("resolver failure")
+		},
+	})
+	if result == nil || !result.Empty() {
+		t.Fatalf("expected empty result after panic, got %+v", result)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Text, "resolver failure") {
+		t.Errorf("expected warning describing the panic, got %v", result.Warnings)
+	}
+}
+
+// --- Test: builtin module member reexports ---
+
+func TestBuiltinMemberReexport(t *testing.T) {
+	for _, opts := range []Options{{}, {NormalizeReexportPaths: true}} {
+		_, reexports := parseTest(t, `module.exports = require("events").EventEmitter;`, opts)
+		assertReexports(t, reexports, "events")
+
+		_, reexports = parseTest(t, `module.exports = require("util").promisify;`, opts)
+		assertReexports(t, reexports, "util")
+
+		_, reexports = parseTest(t, `module.exports = require("node:util").promisify;`, opts)
+		assertReexports(t, reexports, "node:util")
+	}
+}
+
+// --- Test: chained named exports of a require ---
+
+func TestChainedExportsOfRequire(t *testing.T) {
+	result, err := Parse(`exports.foo = exports.bar = require("./x");`, "index.cjs", Options{
+		CollectRequires:    true,
+		TrackExportSources: true,
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "bar,foo")
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./x")
+	for _, name := range []string{"foo", "bar"} {
+		if src := result.ExportSources[name]; src != (ExportSource{Path: "./x"}) {
+			t.Errorf("source of %s: got %+v, want ./x", name, src)
+		}
+	}
+}
+
+// --- Test: DefaultExportName ---
+
+func TestDefaultExportName(t *testing.T) {
+	opts := Options{DefaultExportName: "default"}
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`module.exports = function App() {};`, "default"},
+		{`function App() {} App.version = 1; module.exports = App;`, "default,version"},
+		{`module.exports = class Store {};`, "default"},
+		{`module.exports = "value";`, "default"},
+		{`module.exports = { a: 1 };`, "a"},
+		{`module.exports = require("./x");`, ""},
+		{`exports.a = 1;`, "a"},
+	}
+	for _, tt := range tests {
+		exports, _ := parseTest(t, tt.source, opts)
+		if got := strings.Join(exports, ","); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.source, got, tt.want)
+		}
+	}
+
+	exports, _ := parseTest(t, `module.exports = function App() {};`, Options{})
+	assertExports(t, exports, "")
+}
+
+// --- Test: comma operand requires before Object.assign ---
+
+func TestModuleExportsCommaObjectAssign(t *testing.T) {
+	result, err := Parse(`
+module.exports = (require("./init"), Object.assign({}, require("./a"), { b: 1 }));
+`, "index.cjs", Options{CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "b")
+	assertReexports(t, result.Reexports, "./a")
+	assertRequires(t, result.Requires, "./a,./init")
+}
+
+// --- Test: this.exports inside .call(this) factories ---
+
+func TestThisContextFactory(t *testing.T) {
+	exports, _ := parseTest(t, `
+(function() {
+    this.exports.foo = 1;
+    this.module.exports.bar = 2;
+}).call(this);
+`, Options{})
+	assertExports(t, exports, "bar,foo")
+
+	exports, _ = parseTest(t, `
+(function() {
+    this.module.exports = { a: 1, b: 2 };
+}).apply(this, []);
+`, Options{})
+	assertExports(t, exports, "a,b")
+}
+
+func TestThisContextOutsideFactory(t *testing.T) {
+	exports, _ := parseTest(t, `
+function Loader() {
+    this.exports.foo = 1;
+}
+(function() {
+    this.exports.bar = 1;
+}).call(context);
+`, Options{})
+	assertExports(t, exports, "")
+}
+
+// --- Test: CountOnly ---
+
+func TestCountOnly(t *testing.T) {
+	source := `
+exports.a = 1;
+exports.b = 2;
+__exportStar(require("./x"), exports);
+`
+	result, err := Parse(source, "index.cjs", Options{CountOnly: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.Exports != nil || result.Reexports != nil {
+		t.Errorf("expected nil slices, got %v %v", result.Exports, result.Reexports)
+	}
+	if result.ExportCount != 2 || result.ReexportCount != 1 {
+		t.Errorf("expected counts 2 and 1, got %d and %d", result.ExportCount, result.ReexportCount)
+	}
+	if result.Empty() {
+		t.Errorf("expected CountOnly result to not be empty")
+	}
+
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.ExportCount != len(result.Exports) || result.ReexportCount != len(result.Reexports) {
+		t.Errorf("expected counts to match slices, got %d and %d", result.ExportCount, result.ReexportCount)
+	}
+
+	result, err = Parse(`
+try { exports.c = 1; } catch (e) {}
+exports.d = 1;
+`, "index.cjs", Options{CountOnly: true, MarkConditional: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.Exports != nil || result.ConditionalExports != nil || result.ConditionalReexports != nil {
+		t.Errorf("expected nil slices, got %v %v %v", result.Exports, result.ConditionalExports, result.ConditionalReexports)
+	}
+	if result.ExportCount != 2 {
+		t.Errorf("expected count 2, got %d", result.ExportCount)
+	}
+}
+
+// --- Test: TypeScript enum output ---
+
+// Enum members are assigned onto the enum object, not onto exports, so only
+// the enum itself is a top-level export.
+func TestTypeScriptEnumExports(t *testing.T) {
+	exports, _ := parseTest(t, `
+"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+exports.Color = void 0;
+var Color;
+(function (Color) {
+    Color[Color["Red"] = 0] = "Red";
+    Color[Color["Green"] = 1] = "Green";
+})(Color || (exports.Color = Color = {}));
+`, Options{})
+	assertExports(t, exports, "Color,__esModule")
+
+	// Older output assigns members through exports.Size directly.
+	exports, _ = parseTest(t, `
+exports.Size = exports.Size || {};
+exports.Size[exports.Size["Small"] = 0] = "Small";
+exports.Size[exports.Size["Large"] = 1] = "Large";
+`, Options{})
+	assertExports(t, exports, "Size")
+}
+
+// --- Test: Define in require path concatenation ---
+
+func TestDefineRequirePath(t *testing.T) {
+	source := `
+module.exports = require("./impl-" + process.platform + "-" + process.arch);
+module.exports.native = require(` + "`./native/${process.platform}.node`" + `);
+`
+	opts := Options{Define: map[string]string{
+		"process.platform": "linux",
+		"process.arch":     "x64",
+	}}
+	exports, reexports := parseTest(t, source, opts)
+	assertExports(t, exports, "native")
+	assertReexports(t, reexports, "./impl-linux-x64")
+
+	result, err := Parse(source, "index.cjs", Options{
+		CollectRequires: true,
+		Define:          map[string]string{"process.platform": "darwin"},
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./native/darwin.node")
+
+	_, reexports = parseTest(t, source, Options{})
+	assertReexports(t, reexports, "")
+
+	// Parts that aren't defined leave the path unresolved
+	result, err = Parse(`
+module.exports = require("./impl-" + arch);
+module.exports.native = require(`+"`./native/${arch}-${process.platform}.node`"+`);
+`, "index.cjs", Options{
+		CollectRequires: true,
+		Define:          map[string]string{"process.platform": "linux"},
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "")
+
+	// A bare identifier is folded like the parts of a concatenation
+	_, reexports = parseTest(t, `module.exports = require(PLATFORM);`, Options{
+		Define: map[string]string{"PLATFORM": "linux"},
+	})
+	assertReexports(t, reexports, "linux")
+	_, reexports = parseTest(t, `module.exports = require("./" + PLATFORM);`, Options{
+		Define: map[string]string{"PLATFORM": "linux"},
+	})
+	assertReexports(t, reexports, "./linux")
+	_, reexports = parseTest(t, `module.exports = require(PLATFORM);`, Options{})
+	assertReexports(t, reexports, "")
+}
+
+// --- Test: TSDeclare ---
+
+func TestTSDeclare(t *testing.T) {
+	source := `
+export declare const version: string;
+export declare function create(): Store;
+export declare abstract class Base {}
+export declare enum Mode { A, B }
+export declare namespace utils {
+    const x: number;
+}
+declare class Store {}
+declare const helper: () => void;
+export { Store, helper as help };
+export type { Options } from "./options";
+export interface Config {}
+`
+	result, err := Parse(source, "index.d.ts", Options{Loader: LoaderTS, TSDeclare: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "Base,Mode,Store,create,help,utils,version")
+
+	result, err = Parse(source, "index.d.ts", Options{Loader: LoaderTS})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "")
+}
+
+func TestTSDeclareExportEquals(t *testing.T) {
+	result, err := Parse(`
+declare function debounce(fn: () => void, wait: number): () => void;
+export = debounce;
+`, "index.d.ts", Options{TSDeclare: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.DefaultName != "debounce" {
+		t.Errorf("expected DefaultName debounce, got %q", result.DefaultName)
+	}
+}
+
+// --- Test: optional chaining in if conditions ---
+
+func TestOptionalChainIfCondition(t *testing.T) {
+	for _, opts := range []Options{{}, {NodeEnv: "production"}} {
+		_, reexports := parseTest(t, `
+if (globalThis.process?.env) {
+    module.exports = require("./node");
+}
+`, opts)
+		assertReexports(t, reexports, "./node")
+
+		exports, _ := parseTest(t, `
+if (globalThis.process?.env?.NODE_ENV === "production") {
+    exports.prod = 1;
+} else {
+    exports.dev = 1;
+}
+`, opts)
+		assertExports(t, exports, "dev,prod")
+	}
+}
+
+// --- Test: ExportsIdentifier / ModuleIdentifier ---
+
+func TestCustomModuleIdentifiers(t *testing.T) {
+	source := `
+__e.foo = 1;
+__m.exports.bar = require("./bar");
+exports.ignored = 1;
+`
+	exports, _ := parseTest(t, source, Options{ExportsIdentifier: "__e", ModuleIdentifier: "__m"})
+	assertExports(t, exports, "bar,foo")
+
+	exports, reexports := parseTest(t, `__m.exports = require("./impl");`, Options{ModuleIdentifier: "__m"})
+	assertExports(t, exports, "")
+	assertReexports(t, reexports, "./impl")
+
+	exports, _ = parseTest(t, source, Options{})
+	assertExports(t, exports, "ignored")
+}
+
+// --- Test: Object.assign with a spread array of modules ---
+
+func TestObjectAssignSpreadArray(t *testing.T) {
+	exports, reexports := parseTest(t, `
+Object.assign(module.exports, ...[require("./a"), require("./b"), { c: 1 }]);
+Object.assign(exports, ...modules);
+`, Options{})
+	assertExports(t, exports, "c")
+	assertReexports(t, reexports, "./a,./b")
+}
+
+// --- Test: Result.Diff ---
+
+func TestResultDiff(t *testing.T) {
+	prev, err := Parse(`
+exports.a = 1;
+exports.b = 1;
+__exportStar(require("./x"), exports);
+`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	cur, err := Parse(`
+exports.b = 1;
+exports.d = 1;
+exports.c = 1;
+__exportStar(require("./y"), exports);
+`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	addedExports, removedExports, addedReexports, removedReexports := cur.Diff(prev)
+	assertExports(t, addedExports, "c,d")
+	assertExports(t, removedExports, "a")
+	assertReexports(t, addedReexports, "./y")
+	assertReexports(t, removedReexports, "./x")
+
+	addedExports, removedExports, _, _ = cur.Diff(nil)
+	assertExports(t, addedExports, "b,c,d")
+	assertExports(t, removedExports, "")
+
+	addedExports, removedExports, addedReexports, removedReexports = cur.Diff(cur)
+	if addedExports != nil || removedExports != nil || addedReexports != nil || removedReexports != nil {
+		t.Errorf("expected no differences against itself")
+	}
+}
+
+// --- Test: hoisted exports.default = void 0 ---
+
+func TestHoistedVoidDefault(t *testing.T) {
+	result, err := Parse(`
+"use strict";
+Object.defineProperty(exports, "__esModule", { value: true });
+exports.default = exports.helper = void 0;
+function App() {}
+exports.default = App;
+exports.helper = require("./helpers").helper;
+exports.helper = void 0;
+`, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "__esModule,default,helper")
+	if !result.HasDefault {
+		t.Errorf("expected HasDefault to be true")
+	}
+	if result.DefaultName != "App" {
+		t.Errorf("expected DefaultName App, got %q", result.DefaultName)
+	}
+	if src := result.ExportSources["helper"]; src != (ExportSource{Path: "./helpers", Member: "helper"}) {
+		t.Errorf("expected helper source to survive void 0, got %+v", src)
+	}
+}
+
+// --- Test: HasUnknownExports ---
+
+func TestHasUnknownExports(t *testing.T) {
+	for _, source := range []string{
+		`module.exports = Object.entries(handlers).reduce((acc, [k, v]) => ({ ...acc, [k]: v }), {});`,
+		`module.exports = Object.fromEntries(names.map((name) => [name, create(name)]));`,
+		`module.exports = names.map(load);`,
+	} {
+		result, err := Parse(source, "index.cjs", Options{ReportUnknownPatterns: true})
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if !result.HasUnknownExports {
+			t.Errorf("%s: expected HasUnknownExports", source)
+		}
+		if result.Empty() {
+			t.Errorf("%s: expected a non-empty result", source)
+		}
+		if len(result.Warnings) == 0 {
+			t.Errorf("%s: expected a warning", source)
+		}
+	}
+
+	for _, source := range []string{
+		`module.exports = { a: 1 };`,
+		`module.exports = require("./a");`,
+		`module.exports = build(); module.exports = { a: 1 };`,
+	} {
+		result, err := Parse(source, "index.cjs", Options{})
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if result.HasUnknownExports {
+			t.Errorf("%s: expected HasUnknownExports to be false", source)
+		}
+	}
+}
+
+// --- Test: destructured require reexported through an object ---
+
+func TestDestructuredRequireSources(t *testing.T) {
+	result, err := Parse(`
+const { a, b: renamed } = require("./x");
+const local = 1;
+module.exports = { a, b: renamed, local };
+`, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b,local")
+	assertReexports(t, result.Reexports, "")
+	want := map[string]ExportSource{
+		"a": {Path: "./x", Member: "a"},
+		"b": {Path: "./x", Member: "b"},
+	}
+	if len(result.ExportSources) != len(want) {
+		t.Fatalf("expected %d sources, got %v", len(want), result.ExportSources)
+	}
+	for name, src := range want {
+		if result.ExportSources[name] != src {
+			t.Errorf("source of %s: got %+v, want %+v", name, result.ExportSources[name], src)
+		}
+	}
+
+	result, err = Parse(`
+const { helper } = require("./util");
+exports.helper = helper;
+`, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if src := result.ExportSources["helper"]; src != (ExportSource{Path: "./util", Member: "helper"}) {
+		t.Errorf("source of helper: got %+v", src)
+	}
+}
+
+// --- Test: RequireLikeFunctions ---
+
+func TestRequireLikeFunctions(t *testing.T) {
+	source := `
+module.exports = loadModule("./impl", { cache: true });
+module.exports.helpers = __importStar(require("./helpers"));
+`
+	result, err := Parse(source, "index.cjs", Options{
+		RequireLikeFunctions: []string{"loadModule", "__importStar", "__importDefault"},
+		CollectRequires:      true,
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "helpers")
+	assertReexports(t, result.Reexports, "./impl")
+	assertRequires(t, result.Requires, "./helpers,./impl")
+
+	_, reexports := parseTest(t, source, Options{})
+	assertReexports(t, reexports, "")
+
+	_, reexports = parseTest(t, `__exportStar(__importDefault(require("./extra")), exports);`, Options{
+		RequireLikeFunctions: []string{"__importDefault"},
+	})
+	assertReexports(t, reexports, "./extra")
+}
+
+// --- Test: object assigned after its declaration ---
+
+func TestObjectAssignedAfterDeclaration(t *testing.T) {
+	exports, _ := parseTest(t, `
+let api;
+api = { version: 1 };
+api.create = function() {};
+module.exports = api;
+`, Options{})
+	assertExports(t, exports, "create,version")
+}
+
+// --- Test: minified module wrapper parameters ---
+
+func TestMinifiedWrapperParams(t *testing.T) {
+	exports, _ := parseTest(t, `
+(function(e, t) {
+    t.foo = 1;
+    e.exports.bar = 2;
+})(module, exports);
+`, Options{})
+	assertExports(t, exports, "bar,foo")
+
+	exports, _ = parseTest(t, `
+((e, t) => {
+    t.baz = 1;
+})(module, exports);
+`, Options{})
+	assertExports(t, exports, "baz")
+
+	exports, _ = parseTest(t, `
+(function(e) {
+    e.exports = { a: 1, b: 2 };
+})(module);
+`, Options{})
+	assertExports(t, exports, "a,b")
+
+	exports, _ = parseTest(t, `
+(function(e, t) {
+    t.foo = 1;
+})(other, thing);
+`, Options{})
+	assertExports(t, exports, "")
+}
+
+// --- Test: Result.ToExportMap ---
+
+func TestToExportMap(t *testing.T) {
+	source := `
+exports.local = 1;
+exports.api = require("./impl").api;
+exports.util = require("./util");
+`
+	result, err := Parse(source, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	got := result.ToExportMap()
+	want := map[string]string{"local": "", "api": "./impl", "util": "./util"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	got = result.ToExportMap()
+	want = map[string]string{"local": "", "api": "", "util": ""}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("without sources: got %v, want %v", got, want)
+	}
+}
+
+// --- Test: __proto__ prototype reexport ---
+
+func TestProtoReexport(t *testing.T) {
+	exports, reexports := parseTest(t, `
+module.exports.__proto__ = require("./base");
+module.exports.own = 1;
+`, Options{})
+	assertExports(t, exports, "own")
+	assertReexports(t, reexports, "./base")
+
+	exports, reexports = parseTest(t, `
+module.exports = { __proto__: require("./base"), own: 1 };
+`, Options{})
+	assertExports(t, exports, "own")
+	assertReexports(t, reexports, "./base")
+
+	exports, reexports = parseTest(t, `exports.__proto__ = Base.prototype;`, Options{})
+	assertExports(t, exports, "")
+	assertReexports(t, reexports, "")
+}
+
+// --- Test: ClassifyReexports ---
+
+func TestClassifyReexports(t *testing.T) {
+	result, err := Parse(`
+__exportStar(require("./impl"), exports);
+__exportStar(require("../shared"), exports);
+__exportStar(require("events"), exports);
+__exportStar(require("fs/promises"), exports);
+__exportStar(require("node:path"), exports);
+__exportStar(require("lodash/fp"), exports);
+__exportStar(require("@scope/pkg"), exports);
+`, "index.cjs", Options{ClassifyReexports: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := map[string]string{
+		"./impl":      ReexportRelative,
+		"../shared":   ReexportRelative,
+		"events":      ReexportBuiltin,
+		"fs/promises": ReexportBuiltin,
+		"node:path":   ReexportBuiltin,
+		"lodash/fp":   ReexportPackage,
+		"@scope/pkg":  ReexportPackage,
+	}
+	if fmt.Sprint(result.ReexportKinds) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", result.ReexportKinds, want)
+	}
+
+	result, err = Parse(`module.exports = require("fs");`, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.ReexportKinds != nil {
+		t.Errorf("expected no ReexportKinds by default, got %v", result.ReexportKinds)
+	}
+}
+
+// --- Test: unresolved ternary of requires ---
+
+func TestUnresolvedTernaryRequires(t *testing.T) {
+	source := `module.exports = hasNative() ? require("./native") : require("./js");`
+	_, reexports := parseTest(t, source, Options{NodeEnv: "production"})
+	assertReexports(t, reexports, "./js,./native")
+
+	result, err := Parse(source, "index.cjs", Options{MarkConditional: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertReexports(t, result.Reexports, "./js,./native")
+	assertReexports(t, result.ConditionalReexports, "./js,./native")
+
+	result, err = Parse(`module.exports = process.env.NODE_ENV === "production" ? require("./prod") : require("./dev");`,
+		"index.cjs", Options{NodeEnv: "production", MarkConditional: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertReexports(t, result.Reexports, "./prod")
+	assertReexports(t, result.ConditionalReexports, "")
+}
+
+// --- Test: CopyPropsFunctions ---
+
+func TestCopyPropsFunctions(t *testing.T) {
+	source := `
+function copyProps(target, source) {
+    for (var key in source) target[key] = source[key];
+}
+var util = require("./util");
+copyProps(exports, require("./a"));
+copyProps(module.exports, util);
+copyProps(exports, { extra: 1 });
+copyProps(other, require("./ignored"));
+`
+	exports, reexports := parseTest(t, source, Options{CopyPropsFunctions: []string{"copyProps"}})
+	assertExports(t, exports, "extra")
+	assertReexports(t, reexports, "./a,./util")
+
+	exports, reexports = parseTest(t, source, Options{})
+	assertExports(t, exports, "")
+	assertReexports(t, reexports, "")
+}
+
+// --- Test: async IIFE ---
+
+func TestAsyncIIFE(t *testing.T) {
+	result, err := Parse(`
+(async function() {
+    module.exports = await build();
+})();
+`, "index.cjs", Options{ReportUnknownPatterns: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "")
+	if !result.HasUnknownExports {
+		t.Errorf("expected HasUnknownExports for an awaited value")
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Text, "EAwait") {
+		t.Errorf("expected an EAwait warning, got %v", result.Warnings)
+	}
+
+	exports, _ := parseTest(t, `
+(async () => {
+    exports.ready = await init();
+    exports.version = 1;
+})();
+`, Options{})
+	assertExports(t, exports, "ready,version")
+}
+
+// --- Test: byte order marks and UTF-16 sources ---
+
+func encodeUTF16(s string, bigEndian bool) string {
+	var b []byte
+	for _, u := range utf16.Encode([]rune("\ufeff" + s)) {
+		if bigEndian {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return string(b)
+}
+
+func TestSourceEncoding(t *testing.T) {
+	source := "/** @exports extra */\nexports.a = 1;\nexports.café = 2;\n"
+	opts := Options{HonorExportDirectives: true}
+
+	exports, _ := parseTest(t, "\ufeff"+source, opts)
+	assertExports(t, exports, "a,café,extra")
+
+	exports, _ = parseTest(t, encodeUTF16(source, false), opts)
+	assertExports(t, exports, "a,café,extra")
+
+	exports, _ = parseTest(t, encodeUTF16(source, true), opts)
+	assertExports(t, exports, "a,café,extra")
+
+	// Without a byte order mark, UTF-16 needs an explicit encoding
+	leNoBOM := encodeUTF16(source, false)[2:]
+	opts.Encoding = EncodingUTF16LE
+	exports, _ = parseTest(t, leNoBOM, opts)
+	assertExports(t, exports, "a,café,extra")
+}
+
+// --- Test: export of a require alias ---
+
+func TestExportOfRequireAliasSource(t *testing.T) {
+	result, err := Parse(`
+const bar = require("./bar");
+exports.foo = bar;
+`, "index.cjs", Options{TrackExportSources: true, CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "foo")
+	assertReexports(t, result.Reexports, "")
+	assertRequires(t, result.Requires, "./bar")
+	if src := result.ExportSources["foo"]; src != (ExportSource{Path: "./bar"}) {
+		t.Errorf("source of foo: got %+v, want ./bar", src)
+	}
+}
+
+// --- Test: module.parent library/CLI guards ---
+
+func TestModuleParentGuard(t *testing.T) {
+	negated := `
+if (!module.parent) {
+    exports.cli = 1;
+} else {
+    exports.api = 1;
+}
+`
+	positive := `
+if (module.parent) {
+    exports.api = 1;
+} else {
+    exports.cli = 1;
+}
+`
+	for _, source := range []string{negated, positive} {
+		exports, _ := parseTest(t, source, Options{})
+		assertExports(t, exports, "api")
+
+		exports, _ = parseTest(t, source, Options{RequireMainIsModule: true})
+		assertExports(t, exports, "cli")
+	}
+}
+
+// --- Test: DetectModuleType ---
+
+func TestDetectModuleType(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`import x from "x"; console.log(x);`, ModuleTypeESM},
+		{`export const a = 1;`, ModuleTypeESM},
+		{`await Promise.resolve();`, ModuleTypeESM},
+		{`exports.a = 1;`, ModuleTypeCommonJS},
+		{`module.exports = function () {};`, ModuleTypeCommonJS},
+		{`const x = require("x"); x();`, ModuleTypeCommonJS},
+		{`import x from "x"; module.exports = x;`, ModuleTypeAmbiguous},
+		{`console.log("hello");`, ModuleTypeAmbiguous},
+		{`import("x").then(console.log);`, ModuleTypeAmbiguous},
+		{`function f(module) { module.exports = 1; }`, ModuleTypeAmbiguous},
+	}
+	for _, tt := range tests {
+		got, err := DetectModuleType(tt.src)
+		if err != nil {
+			t.Fatalf("DetectModuleType(%q): %v", tt.src, err)
+		}
+		if got != tt.want {
+			t.Errorf("DetectModuleType(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+
+	if _, err := DetectModuleType(`import {`); err == nil {
+		t.Error("expected a parse error")
+	}
+}
+
+// --- Test: Object.assign later arguments shadow earlier export sources ---
+func TestObjectAssignShadowedSource(t *testing.T) {
+	source := `
+		exports.a = require("./x").a;
+		Object.assign(exports, require("./base"), { a: localOverride, b: require("./y").b });
+	`
+	resolve := func(importer, path string) (string, *Result, bool) {
+		if path == "./base" {
+			return path, &Result{Exports: []string{"a", "c"}}, true
+		}
+		return "", nil, false
+	}
+	result, err := Parse(source, "index.cjs", Options{ResolveReexport: resolve, TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b,c")
+	if got, ok := result.ExportSources["a"]; ok {
+		t.Errorf("local a should take precedence, got source %+v", got)
+	}
+	if got := result.ExportSources["b"]; got != (ExportSource{Path: "./y", Member: "b"}) {
+		t.Errorf("b source: got %+v", got)
+	}
+	if got := result.ExportSources["c"]; got != (ExportSource{Path: "./base", Member: "c"}) {
+		t.Errorf("c source: got %+v", got)
+	}
+}
+
+// --- Test: primitive and buffer default exports ---
+func TestPrimitiveDefaultExports(t *testing.T) {
+	for _, source := range []string{
+		`exports.dropped = 1; module.exports = "some string"`,
+		`exports.dropped = 1; module.exports = 42`,
+		`exports.dropped = 1; module.exports = -1`,
+		"exports.dropped = 1; module.exports = `text`",
+		`exports.dropped = 1; module.exports = false`,
+		`exports.dropped = 1; module.exports = Buffer.from("x")`,
+	} {
+		result, err := Parse(source, "index.cjs", Options{})
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", source, err)
+		}
+		if len(result.Exports) != 0 || len(result.Reexports) != 0 {
+			t.Errorf("%q: expected no names, got %v %v", source, result.Exports, result.Reexports)
+		}
+		if !result.HasDefault {
+			t.Errorf("%q: expected HasDefault", source)
+		}
+		if result.HasUnknownExports {
+			t.Errorf("%q: expected no unknown exports", source)
+		}
+	}
+}
+
+// --- Test: warnings are reported in source order ---
+func TestWarningsSourceOrder(t *testing.T) {
+	source := `
+		if (a) module.exports = first();
+		else module.exports = second();
+	`
+	var prev []Warning
+	for i := 0; i < 5; i++ {
+		result, err := Parse(source, "index.cjs", Options{ReportUnknownPatterns: true})
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		if len(result.Warnings) != 2 {
+			t.Fatalf("expected 2 warnings, got %v", result.Warnings)
+		}
+		if got, want := int(result.Warnings[0].Loc.Start), strings.Index(source, "first"); got != want {
+			t.Errorf("expected first warning at offset %d, got %d", want, got)
+		}
+		if got, want := int(result.Warnings[1].Loc.Start), strings.Index(source, "second"); got != want {
+			t.Errorf("expected second warning at offset %d, got %d", want, got)
+		}
+		if prev != nil && fmt.Sprint(prev) != fmt.Sprint(result.Warnings) {
+			t.Errorf("warnings changed between runs: %v vs %v", prev, result.Warnings)
+		}
+		prev = result.Warnings
+	}
+}
+
+// --- Test: Object.assign onto a null-prototype object ---
+func TestObjectAssignObjectCreateNull(t *testing.T) {
+	exports, reexports := parseTest(t, `
+		module.exports = Object.assign(Object.create(null), { a: 1, b: 2 }, require("./c"));
+	`, Options{})
+	assertExports(t, exports, "a,b")
+	assertReexports(t, reexports, "./c")
+
+	exports, reexports = parseTest(t, `
+		module.exports = Object.assign(Object.create({}), { a: 1 });
+	`, Options{})
+	assertExports(t, exports, "a")
+	assertReexports(t, reexports, "")
+}
+
+// --- Test: ESModuleMarkerLast ---
+func TestESModuleMarkerLast(t *testing.T) {
+	source := `
+		Object.defineProperty(exports, "__esModule", { value: true });
+		exports.Z = 1;
+		exports.a = 2;
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExports(t, exports, "Z,__esModule,a")
+
+	exports, _ = parseTest(t, source, Options{ESModuleMarkerLast: true})
+	assertExports(t, exports, "Z,a,__esModule")
+}
+
+// --- Test: tslib __assign into exports ---
+func TestTslibAssign(t *testing.T) {
+	exports, reexports := parseTest(t, `
+		var tslib_1 = require("tslib");
+		tslib_1.__assign(exports, { a: 1 }, require("./b"));
+	`, Options{})
+	assertExports(t, exports, "a")
+	assertReexports(t, reexports, "./b")
+
+	exports, reexports = parseTest(t, `
+		var tslib_1 = require("tslib");
+		(0, tslib_1.__assign)(module.exports, { a: 1, c: 2 });
+	`, Options{})
+	assertExports(t, exports, "a,c")
+	assertReexports(t, reexports, "")
+
+	exports, _ = parseTest(t, `
+		var __assign = (this && this.__assign) || function () { return Object.assign.apply(this, arguments); };
+		__assign(exports, { a: 1 });
+		module.exports = __assign({}, { d: 1 });
+	`, Options{})
+	assertExports(t, exports, "d")
+
+	// __assign on anything other than tslib is an ordinary method call
+	exports, _ = parseTest(t, `
+		var foo = require("./foo");
+		foo.__assign(exports, { a: 1 });
+		bar.__assign(module.exports, { b: 1 });
+	`, Options{})
+	assertExports(t, exports, "")
+}
+
+// --- Test: ReexportedNames lists the names each resolved reexport contributes ---
+func TestReexportedNames(t *testing.T) {
+	source := `
+		module.exports = { ...require("./a"), ...require("./b"), ...require("./missing"), local: 1 }
+	`
+	resolve := func(importer, path string) (string, *Result, bool) {
+		switch path {
+		case "./a":
+			return path, &Result{Exports: []string{"local", "x", "y"}}, true
+		case "./b":
+			return path, &Result{Exports: []string{"y", "z"}}, true
+		}
+		return "", nil, false
+	}
+	result, err := Parse(source, "index.cjs", Options{ResolveReexport: resolve})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "local,x,y,z")
+	if got := fmt.Sprint(result.ReexportedNames); got != "map[./a:[x y] ./b:[z]]" {
+		t.Errorf("ReexportedNames: got %s", got)
+	}
+
+	result, err = Parse(source, "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.ReexportedNames != nil {
+		t.Errorf("expected no ReexportedNames without a resolver, got %v", result.ReexportedNames)
+	}
+}
+
+// --- Test: exports.b after module.exports = require() is dead ---
+func TestDeadExportsAfterOverride(t *testing.T) {
+	source := `module.exports = require("./a"); exports.b = 1;`
+	result, err := Parse(source, "index.cjs", Options{ReportUnknownPatterns: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "")
+	assertReexports(t, result.Reexports, "./a")
+	if len(result.Warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", result.Warnings)
+	}
+	warning := result.Warnings[0]
+	if !strings.Contains(warning.Text, "exports.b") {
+		t.Errorf("expected warning to name exports.b, got %q", warning.Text)
+	}
+	if got, want := int(warning.Loc.Start), strings.Index(source, "exports.b"); got != want {
+		t.Errorf("expected warning at offset %d, got %d", want, got)
+	}
+
+	// module.exports.b still writes to the replaced object
+	result, err = Parse(`module.exports = require("./a"); module.exports.b = 1;`, "index.cjs", Options{ReportUnknownPatterns: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "b")
+	if len(result.Warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", result.Warnings)
+	}
+}
+
+// --- Test: computed exports index with a numeric constant ---
+func TestExportsNumericConstIndex(t *testing.T) {
+	exports, _ := parseTest(t, `
+		const IDX = 0;
+		const KEY = "named";
+		let dynamic = 1;
+		exports[IDX] = "zero";
+		exports[2] = "two";
+		module.exports[KEY] = 1;
+		exports[dynamic] = "skipped";
+		exports[i++] = "skipped";
+	`, Options{})
+	assertExports(t, exports, "0,2,named")
+}
+
+// --- Test: __exportStar with an object of required values ---
+func TestExportStarObjectRequireValues(t *testing.T) {
+	source := `
+		var tslib_1 = require("tslib");
+		tslib_1.__exportStar({
+			a: require("./a").a,
+			get b() { return require("./b").b; },
+			c: 1,
+		}, exports);
+	`
+	result, err := Parse(source, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b,c")
+	assertReexports(t, result.Reexports, "")
+	if got := result.ExportSources["a"]; got != (ExportSource{Path: "./a", Member: "a"}) {
+		t.Errorf("a source: got %+v", got)
+	}
+	if got := result.ExportSources["b"]; got != (ExportSource{Path: "./b", Member: "b"}) {
+		t.Errorf("b source: got %+v", got)
+	}
+
+	exports, reexports := parseTest(t, source, Options{IncludeReexportFromExportStarObject: true})
+	assertExports(t, exports, "a,b,c")
+	assertReexports(t, reexports, "./a,./b")
+}
+
+// --- Test: ESM export default names ---
+func TestESMExportDefault(t *testing.T) {
+	tests := []struct {
+		source      string
+		defaultName string
+	}{
+		{`export default function Foo() {}`, "Foo"},
+		{`export default class Bar {}`, "Bar"},
+		{`const Baz = 1; export default Baz;`, "Baz"},
+		{`export default function () {}`, ""},
+		{`export default class {}`, ""},
+		{`export default {};`, ""},
+		{`export default 42;`, ""},
+	}
+	for _, tt := range tests {
+		result, err := Parse(tt.source, "index.mjs", Options{IncludeESMExports: true})
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.source, err)
+		}
+		assertExports(t, result.Exports, "default")
+		if !result.HasDefault {
+			t.Errorf("%q: expected HasDefault", tt.source)
+		}
+		if result.DefaultName != tt.defaultName {
+			t.Errorf("%q: expected DefaultName %q, got %q", tt.source, tt.defaultName, result.DefaultName)
+		}
+	}
+
+	// ESM exports are ignored unless requested
+	exports, _ := parseTest(t, `export default function Foo() {}`, Options{})
+	assertExports(t, exports, "")
+}
+
+// --- Test: ESM named exports ---
+func TestESMNamedExports(t *testing.T) {
+	exports, reexports := parseTest(t, `
+		export const a = 1, { b, c: [d] } = obj;
+		export function e() {}
+		export class F {}
+		const g = 1;
+		export { g, g as h };
+		export { i } from "./i";
+		export * as ns from "./ns";
+		export * from "./star";
+	`, Options{IncludeESMExports: true})
+	assertExports(t, exports, "F,a,b,d,e,g,h,i,ns")
+	assertReexports(t, reexports, "./star")
+}
+
+// --- Test: required factory called with a required dependency ---
+func TestRequireFactoryWithRequiredArg(t *testing.T) {
+	result, err := Parse(`
+		module.exports = require("./factory")(require("./config"), require("./logger").default);
+	`, "index.cjs", Options{CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "")
+	assertReexports(t, result.Reexports, "./factory()")
+	assertRequires(t, result.Requires, "./config,./factory,./logger")
+}
+
+// --- Test: spread of a local function result ---
+func TestObjectSpreadFromFunctionResult(t *testing.T) {
+	source := `
+		function makeExports() {
+			return { a: 1, b: 2 };
+		}
+		module.exports = { ...makeExports(), extra: 1 };
+	`
+	exports, _ := parseTest(t, source, Options{})
+	assertExports(t, exports, "extra")
+
+	exports, _ = parseTest(t, source, Options{ObjectSpreadFromFunctionResult: true})
+	assertExports(t, exports, "a,b,extra")
+
+	exports, _ = parseTest(t, source, Options{CallMode: true})
+	assertExports(t, exports, "a,b,extra")
+}
+
+// --- Test: whole-module and selective reexports of the same path ---
+func TestStarAndSelectiveReexportSamePath(t *testing.T) {
+	tests := []struct {
+		source  string
+		exports string
+	}{
+		{`__exportStar(require("./a"), exports); exports.foo = require("./a").foo;`, "foo"},
+		{`Object.assign(exports, require("./a"), { foo: require("./a").foo }); __exportStar(require("./a"), exports);`, "foo"},
+		{`module.exports = { ...require("./a"), ...require("./a"), foo: require("./a").foo };`, "foo"},
+	}
+	for _, tt := range tests {
+		result, err := Parse(tt.source, "index.cjs", Options{TrackExportSources: true})
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.source, err)
+		}
+		assertExports(t, result.Exports, tt.exports)
+		assertReexports(t, result.Reexports, "./a")
+		if got := result.ExportSources["foo"]; got != (ExportSource{Path: "./a", Member: "foo"}) {
+			t.Errorf("%q: foo source: got %+v", tt.source, got)
+		}
+	}
+}
+
+// --- Test: module.exports assigned through a global in a chain ---
+func TestModuleExportsGlobalChain(t *testing.T) {
+	exports, _ := parseTest(t, `module.exports = global.MyLib = { foo: 1 };`, Options{})
+	assertExports(t, exports, "foo")
+
+	exports, reexports := parseTest(t, `
+		module.exports = window.MyLib = globalThis.MyLib = { foo: 1, ...require("./base") };
+	`, Options{})
+	assertExports(t, exports, "foo")
+	assertReexports(t, reexports, "./base")
+
+	// exports is rebound to the new object, so later assignments still count
+	exports, _ = parseTest(t, `
+		exports.dropped = 1;
+		module.exports = exports = { foo: 1 };
+		exports.bar = 2;
+	`, Options{})
+	assertExports(t, exports, "bar,foo")
+}
+
+// --- Test: OnlyReexports ---
+func TestOnlyReexports(t *testing.T) {
+	source := `
+		exports.a = 1;
+		Object.defineProperty(exports, "b", { enumerable: true, get: function () { return 2; } });
+		module.exports.__proto__ = require("./proto");
+		__exportStar(require("./star"), exports);
+		Object.assign(module.exports, { c: 1 }, require("./assigned"));
+		exports.d = require("./member").d;
+	`
+	result, err := Parse(source, "index.cjs", Options{OnlyReexports: true, CollectRequires: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.Exports != nil || result.ExportCount != 0 {
+		t.Errorf("expected no exports, got %v", result.Exports)
+	}
+	assertReexports(t, result.Reexports, "./assigned,./proto,./star")
+	assertRequires(t, result.Requires, "./assigned,./member,./proto,./star")
+}
+
+func reexportCorpus() string {
+	var sb strings.Builder
+	sb.WriteString("\"use strict\";\nObject.defineProperty(exports, \"__esModule\", { value: true });\n")
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&sb, "__exportStar(require(\"./mod%d\"), exports);\n", i)
+		fmt.Fprintf(&sb, "exports.name%d = require(\"./named%d\").name%d;\n", i, i, i)
+	}
+	return sb.String()
+}
+
+func BenchmarkOnlyReexports(b *testing.B) {
+	source := reexportCorpus()
+	for _, only := range []bool{false, true} {
+		b.Run(fmt.Sprintf("only=%v", only), func(b *testing.B) {
+			opts := Options{OnlyReexports: only}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Parse(source, "index.cjs", opts)
+			}
+		})
+	}
+}
+
+// --- Test: Babel's trailing exports.default = _default ---
+func TestBabelDefaultVariable(t *testing.T) {
+	result, err := Parse(`
+		Object.defineProperty(exports, "__esModule", { value: true });
+		exports.default = void 0;
+		var _default = require("./App");
+		exports.default = _default;
+	`, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "__esModule,default")
+	if got := result.ExportSources["default"]; got != (ExportSource{Path: "./App"}) {
+		t.Errorf("default source: got %+v", got)
+	}
+
+	// The declaration and the assignment may be split
+	result, err = Parse(`
+		var _default;
+		_default = require("./App");
+		exports.default = _default;
+	`, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "default")
+	if got := result.ExportSources["default"]; got != (ExportSource{Path: "./App"}) {
+		t.Errorf("split default source: got %+v", got)
+	}
+
+	for _, source := range []string{
+		`var _default = function Foo() {}; exports.default = _default;`,
+		`var _default; _default = function Foo() {}; exports.default = _default;`,
+		`var _default = class Foo {}; exports.default = _default;`,
+	} {
+		result, err := Parse(source, "index.cjs", Options{})
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", source, err)
+		}
+		if !result.HasDefault || result.DefaultName != "Foo" {
+			t.Errorf("%q: expected default named Foo, got %v %q", source, result.HasDefault, result.DefaultName)
+		}
+	}
+}
+
+// --- Test: defineProperty getters forwarding a required member ---
+func TestDefinePropertyGetterSource(t *testing.T) {
+	result, err := Parse(`
+		"use strict";
+		Object.defineProperty(exports, "__esModule", { value: true });
+		var y_1 = require("./y");
+		Object.defineProperty(exports, "x", { enumerable: true, get: function () { return y_1.x; } });
+		Object.defineProperty(exports, "z", { enumerable: true, get: () => y_1.renamed });
+		Object.defineProperty(exports, "local", { enumerable: true, get: function () { return local; } });
+	`, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "__esModule,local,x,z")
+	if got := result.ExportSources["x"]; got != (ExportSource{Path: "./y", Member: "x"}) {
+		t.Errorf("x source: got %+v", got)
+	}
+	if got := result.ExportSources["z"]; got != (ExportSource{Path: "./y", Member: "renamed"}) {
+		t.Errorf("z source: got %+v", got)
+	}
+	if got, ok := result.ExportSources["local"]; ok {
+		t.Errorf("expected no source for local, got %+v", got)
+	}
+}
+
+// --- Test: nested reexports resolved with cycle and depth guards ---
+func TestMaxReexportDepth(t *testing.T) {
+	// ./a and ./b reexport each other
+	cyclic := func(importer, path string) (string, *Result, bool) {
+		switch path {
+		case "./a":
+			return path, &Result{Exports: []string{"a"}, Reexports: []string{"./b"}}, true
+		case "./b":
+			return path, &Result{Exports: []string{"b"}, Reexports: []string{"./a"}}, true
+		}
+		return "", nil, false
+	}
+	result, err := Parse(`module.exports = require("./a")`, "index.cjs", Options{
+		ResolveReexport:       cyclic,
+		ReportUnknownPatterns: true,
+		TrackExportSources:    true,
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b")
+	assertReexports(t, result.Reexports, "./a")
+	if got := result.ExportSources["b"]; got != (ExportSource{Path: "./a", Member: "b"}) {
+		t.Errorf("b source: got %+v", got)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Text, "cycle") {
+		t.Errorf("expected a cycle warning, got %v", result.Warnings)
+	}
+
+	// ./m0 -> ./m1 -> ... -> ./m9
+	chain := func(importer, path string) (string, *Result, bool) {
+		var i int
+		if _, err := fmt.Sscanf(path, "./m%d", &i); err != nil {
+			return "", nil, false
+		}
+		return path, &Result{Exports: []string{fmt.Sprintf("e%d", i)}, Reexports: []string{fmt.Sprintf("./m%d", i+1)}}, i < 9
+	}
+	result, err = Parse(`module.exports = require("./m0")`, "index.cjs", Options{
+		ResolveReexport:       chain,
+		MaxReexportDepth:      3,
+		ReportUnknownPatterns: true,
+	})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "e0,e1,e2")
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0].Text, "depth limit 3") {
+		t.Errorf("expected a depth warning, got %v", result.Warnings)
+	}
+
+	exports, _ := parseTest(t, `module.exports = require("./m0")`, Options{ResolveReexport: chain})
+	assertExports(t, exports, "e0,e1,e2,e3,e4,e5,e6,e7")
+}
+
+// --- Test: frozen Object.assign result ---
+func TestModuleExportsFrozenAssign(t *testing.T) {
+	exports, reexports := parseTest(t, `
+		module.exports = Object.freeze(Object.assign({}, require("./base"), { x: 1 }));
+	`, Options{})
+	assertExports(t, exports, "x")
+	assertReexports(t, reexports, "./base")
+
+	exports, reexports = parseTest(t, `
+		module.exports = Object.seal(Object.freeze({ a: 1, ...require("./b") }));
+	`, Options{})
+	assertExports(t, exports, "a")
+	assertReexports(t, reexports, "./b")
+}
+
+// --- Test: CollectSourceMetrics ---
+func TestCollectSourceMetrics(t *testing.T) {
+	tests := []struct {
+		source string
+		lines  int
+	}{
+		{"", 0},
+		{"exports.a = 1", 1},
+		{"exports.a = 1\n", 1},
+		{"exports.a = 1;\r\nexports.b = 2;\r\n\r\nexports.c = 3", 4},
+	}
+	for _, tt := range tests {
+		result, err := Parse(tt.source, "index.cjs", Options{CollectSourceMetrics: true})
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.source, err)
+		}
+		if result.LineCount != tt.lines {
+			t.Errorf("%q: expected %d lines, got %d", tt.source, tt.lines, result.LineCount)
+		}
+		if result.ByteCount != len(tt.source) {
+			t.Errorf("%q: expected %d bytes, got %d", tt.source, len(tt.source), result.ByteCount)
+		}
+	}
+
+	// A byte order mark is not part of the decoded source
+	result, err := Parse("\ufeffexports.a = 1\n", "index.cjs", Options{CollectSourceMetrics: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.LineCount != 1 || result.ByteCount != len("exports.a = 1\n") {
+		t.Errorf("expected 1 line and %d bytes, got %d and %d", len("exports.a = 1\n"), result.LineCount, result.ByteCount)
+	}
+
+	result, err = Parse("exports.a = 1\n", "index.cjs", Options{})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if result.LineCount != 0 || result.ByteCount != 0 {
+		t.Errorf("expected no metrics by default, got %d and %d", result.LineCount, result.ByteCount)
+	}
+}
+
+// --- Test: for...of over a static names array ---
+func TestForOfStaticNames(t *testing.T) {
+	result, err := Parse(`
+		const impl = require("./impl");
+		for (const name of ["a", "b", "c"]) {
+			exports[name] = impl[name];
+		}
+		for (let key of ["d"]) Object.defineProperty(exports, key, { enumerable: true, get: () => impl[key] });
+		for (const skipped of names) exports[skipped] = 1;
+		for (const skipped of ["e", other]) exports[skipped] = 1;
+	`, "index.cjs", Options{TrackExportSources: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b,c,d")
+	if got := result.ExportSources["b"]; got != (ExportSource{Path: "./impl", Member: "b"}) {
+		t.Errorf("b source: got %+v", got)
+	}
+}
+
+// --- Test: functions and classes assigned to module.exports are the default ---
+func TestFunctionAndClassDefault(t *testing.T) {
+	tests := []struct {
+		source      string
+		defaultName string
+	}{
+		{`module.exports = function App() {};`, "App"},
+		{`module.exports = () => {};`, ""},
+		{`module.exports = class Store {};`, "Store"},
+		{`class C {} module.exports = C;`, "C"},
+		{`function f() {} f.helper = 1; module.exports = f;`, "f"},
+	}
+	for _, tt := range tests {
+		result, err := Parse(tt.source, "index.cjs", Options{})
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.source, err)
+		}
+		if !result.HasDefault {
+			t.Errorf("%q: expected HasDefault", tt.source)
+		}
+		if result.DefaultName != tt.defaultName {
+			t.Errorf("%q: expected DefaultName %q, got %q", tt.source, tt.defaultName, result.DefaultName)
+		}
+	}
+}
+
+// --- Test: TSDeclare forms without declare, and comments and strings ---
+func TestTSDeclareForms(t *testing.T) {
+	result, err := Parse(`
+/*
+export declare const fake: number;
+*/
+// export declare const commented: number;
+export function c(): void;
+export const d: string;
+export async function e(): Promise<void>;
+export default class Foo {}
+export default interface Props {}
+declare const s: "export declare const inString: number";
+`, "index.d.ts", Options{TSDeclare: true})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "c,d,default,e")
+	if result.DefaultName != "Foo" {
+		t.Errorf("expected DefaultName Foo, got %q", result.DefaultName)
+	}
+}
+
+// --- Test: wrapper invoked with .call and .apply maps its parameters ---
+func TestWrapperCallParams(t *testing.T) {
+	exports, _ := parseTest(t, `
+		(function (e, t) { t.foo = 1; e.exports.bar = 2; }).call(this, module, exports);
+	`, Options{})
+	assertExports(t, exports, "bar,foo")
+
+	exports, _ = parseTest(t, `
+		(function (m, t) { t.baz = 1; }).apply(this, [module, exports]);
+	`, Options{})
+	assertExports(t, exports, "baz")
+}
+
+// --- Test: ResolveReexport is called once per importer and path across diamond reexports ---
+func TestResolveReexportDiamond(t *testing.T) {
+	// ./a and ./b both reexport ./c, which reexports ./d
+	calls := map[string]int{}
+	resolve := func(importer, path string) (string, *Result, bool) {
+		calls[importer+" > "+path]++
+		switch path {
+		case "./a", "./b":
+			return path[2:] + ".js", &Result{Exports: []string{path[2:]}, Reexports: []string{"./c"}}, true
+		case "./c":
+			return "c.js", &Result{Exports: []string{"c"}, Reexports: []string{"./d", "./missing"}}, true
+		case "./d":
+			return "d.js", &Result{Exports: []string{"d"}}, true
+		}
+		return "", nil, false
+	}
+	result, err := Parse(`
+		module.exports = { ...require("./a"), ...require("./b") };
+	`, "index.cjs", Options{ResolveReexport: resolve})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b,c,d")
+	want := map[string]int{
+		"index.cjs > ./a":  1,
+		"index.cjs > ./b":  1,
+		"a.js > ./c":       1,
+		"b.js > ./c":       1,
+		"c.js > ./d":       1,
+		"c.js > ./missing": 1,
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("unexpected resolver calls: %v", calls)
+	}
+}
+
+// --- Test: ResolveReexport receives the importer of each reexport ---
+func TestResolveReexportImporter(t *testing.T) {
+	var calls []string
+	resolve := func(importer, path string) (string, *Result, bool) {
+		calls = append(calls, importer+" > "+path)
+		switch path {
+		case "./a":
+			return "/src/a.js", &Result{Exports: []string{"a"}, Reexports: []string{"./lib/b"}}, true
+		case "./lib/b":
+			return "/src/lib/b.js", &Result{Exports: []string{"b"}}, true
+		}
+		return "", nil, false
+	}
+	result, err := Parse(`module.exports = require("./a")`, "/src/index.cjs", Options{ResolveReexport: resolve})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "a,b")
+	if got := strings.Join(calls, ", "); got != "/src/index.cjs > ./a, /src/a.js > ./lib/b" {
+		t.Errorf("unexpected resolver calls: %s", got)
+	}
+}
+
+// --- Test: nested reexports with the same path resolve per importer ---
+func TestResolveReexportSamePathPerImporter(t *testing.T) {
+	calls := 0
+	resolve := func(importer, path string) (string, *Result, bool) {
+		calls++
+		switch importer + " > " + path {
+		case "index.cjs > ./a":
+			return "a.js", &Result{Reexports: []string{"./util"}}, true
+		case "index.cjs > ./b/c":
+			return "b/c.js", &Result{Reexports: []string{"./util"}}, true
+		case "a.js > ./util":
+			return "util.js", &Result{Exports: []string{"rootUtil"}}, true
+		case "b/c.js > ./util":
+			return "b/util.js", &Result{Exports: []string{"nestedUtil"}}, true
+		}
+		return "", nil, false
+	}
+	result, err := Parse(`
+		module.exports = { ...require("./a"), ...require("./b/c") };
+	`, "index.cjs", Options{ResolveReexport: resolve})
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	assertExports(t, result.Exports, "nestedUtil,rootUtil")
+	if calls != 4 {
+		t.Errorf("expected 4 resolver calls, got %d", calls)
+	}
+}